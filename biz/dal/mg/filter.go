@@ -0,0 +1,249 @@
+package mg
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Filter is a fluent builder that produces a MongoDB query document.
+// Operators applied to the same field are merged into a single operator
+// document, so Gte("age", 18).Lt("age", 60) yields {age: {$gte: 18, $lt: 60}}.
+//
+// Every method that has a ByCondition variant only adds the condition when
+// the given boolean is true, which keeps optional query parameters readable.
+//
+// Example:
+//
+//	filter := NewFilter().
+//		Eq("status", 1).
+//		InByCondition(len(tags) > 0, "tags", tags...).
+//		Range("created_at", begin, end).
+//		Build()
+type Filter struct {
+	elements bson.D
+}
+
+// NewFilter creates an empty Filter.
+func NewFilter() *Filter {
+	return &Filter{
+		elements: make(bson.D, 0),
+	}
+}
+
+// Eq matches documents where field equals value.
+func (self *Filter) Eq(field string, value any) *Filter {
+	return self.EqByCondition(true, field, value)
+}
+
+// EqByCondition adds an Eq condition when condition is true.
+func (self *Filter) EqByCondition(condition bool, field string, value any) *Filter {
+	if condition {
+		self.set(field, value)
+	}
+	return self
+}
+
+// Ne matches documents where field does not equal value.
+func (self *Filter) Ne(field string, value any) *Filter {
+	return self.NeByCondition(true, field, value)
+}
+
+// NeByCondition adds a Ne condition when condition is true.
+func (self *Filter) NeByCondition(condition bool, field string, value any) *Filter {
+	return self.operator(condition, field, "$ne", value)
+}
+
+// In matches documents where field equals any of the given values.
+func (self *Filter) In(field string, values ...any) *Filter {
+	return self.InByCondition(true, field, values...)
+}
+
+// InByCondition adds an In condition when condition is true.
+func (self *Filter) InByCondition(condition bool, field string, values ...any) *Filter {
+	return self.operator(condition, field, "$in", bson.A(values))
+}
+
+// Nin matches documents where field equals none of the given values.
+func (self *Filter) Nin(field string, values ...any) *Filter {
+	return self.NinByCondition(true, field, values...)
+}
+
+// NinByCondition adds a Nin condition when condition is true.
+func (self *Filter) NinByCondition(condition bool, field string, values ...any) *Filter {
+	return self.operator(condition, field, "$nin", bson.A(values))
+}
+
+// Gt matches documents where field is greater than value.
+func (self *Filter) Gt(field string, value any) *Filter {
+	return self.GtByCondition(true, field, value)
+}
+
+// GtByCondition adds a Gt condition when condition is true.
+func (self *Filter) GtByCondition(condition bool, field string, value any) *Filter {
+	return self.operator(condition, field, "$gt", value)
+}
+
+// Gte matches documents where field is greater than or equal to value.
+func (self *Filter) Gte(field string, value any) *Filter {
+	return self.GteByCondition(true, field, value)
+}
+
+// GteByCondition adds a Gte condition when condition is true.
+func (self *Filter) GteByCondition(condition bool, field string, value any) *Filter {
+	return self.operator(condition, field, "$gte", value)
+}
+
+// Lt matches documents where field is less than value.
+func (self *Filter) Lt(field string, value any) *Filter {
+	return self.LtByCondition(true, field, value)
+}
+
+// LtByCondition adds a Lt condition when condition is true.
+func (self *Filter) LtByCondition(condition bool, field string, value any) *Filter {
+	return self.operator(condition, field, "$lt", value)
+}
+
+// Lte matches documents where field is less than or equal to value.
+func (self *Filter) Lte(field string, value any) *Filter {
+	return self.LteByCondition(true, field, value)
+}
+
+// LteByCondition adds a Lte condition when condition is true.
+func (self *Filter) LteByCondition(condition bool, field string, value any) *Filter {
+	return self.operator(condition, field, "$lte", value)
+}
+
+// Range matches documents where field lies in the closed interval [lower, upper].
+// A nil bound is skipped, turning the range into a one-sided comparison.
+func (self *Filter) Range(field string, lower, upper any) *Filter {
+	return self.RangeByCondition(true, field, lower, upper)
+}
+
+// RangeByCondition adds a Range condition when condition is true.
+func (self *Filter) RangeByCondition(condition bool, field string, lower, upper any) *Filter {
+	self.operator(condition && lower != nil, field, "$gte", lower)
+	return self.operator(condition && upper != nil, field, "$lte", upper)
+}
+
+// Regex matches documents where field matches the regular expression pattern.
+// options accepts the standard MongoDB regex flags such as "i" or "m".
+func (self *Filter) Regex(field, pattern, options string) *Filter {
+	return self.RegexByCondition(true, field, pattern, options)
+}
+
+// RegexByCondition adds a Regex condition when condition is true.
+func (self *Filter) RegexByCondition(condition bool, field, pattern, options string) *Filter {
+	return self.operator(condition, field, "$regex", bson.Regex{Pattern: pattern, Options: options})
+}
+
+// Exists matches documents that contain (or do not contain) field.
+func (self *Filter) Exists(field string, exists bool) *Filter {
+	return self.operator(true, field, "$exists", exists)
+}
+
+// ElemMatch matches documents whose array field contains at least one element
+// satisfying every condition of sub.
+func (self *Filter) ElemMatch(field string, sub *Filter) *Filter {
+	return self.operator(sub != nil && len(sub.elements) > 0, field, "$elemMatch", sub.Build())
+}
+
+// Or matches documents that satisfy at least one of the given filters.
+// Empty filters are ignored.
+func (self *Filter) Or(filters ...*Filter) *Filter {
+	branches := make(bson.A, 0, len(filters))
+	for _, filter := range filters {
+		if filter == nil || len(filter.elements) == 0 {
+			continue
+		}
+		branches = append(branches, filter.Build())
+	}
+	if len(branches) > 0 {
+		self.elements = append(self.elements, bson.E{Key: "$or", Value: branches})
+	}
+	return self
+}
+
+// Build returns the query document. An empty Filter builds an empty bson.D
+// that matches every document.
+func (self *Filter) Build() bson.D {
+	if self == nil {
+		return bson.D{}
+	}
+	return self.elements
+}
+
+// set assigns value to field, replacing any previous condition on that field.
+func (self *Filter) set(field string, value any) {
+	for i := range self.elements {
+		if self.elements[i].Key == field {
+			self.elements[i].Value = value
+			return
+		}
+	}
+	self.elements = append(self.elements, bson.E{Key: field, Value: value})
+}
+
+// operator adds an operator condition to field, merging with an existing
+// operator document for the same field when present.
+func (self *Filter) operator(condition bool, field, operator string, value any) *Filter {
+	if !condition {
+		return self
+	}
+	for i := range self.elements {
+		if self.elements[i].Key != field {
+			continue
+		}
+		if doc, ok := self.elements[i].Value.(bson.D); ok {
+			self.elements[i].Value = append(doc, bson.E{Key: operator, Value: value})
+			return self
+		}
+		self.elements[i].Value = bson.D{{Key: operator, Value: value}}
+		return self
+	}
+	self.elements = append(self.elements, bson.E{Key: field, Value: bson.D{{Key: operator, Value: value}}})
+	return self
+}
+
+// Projection is a fluent builder for the projection document passed to
+// options.Find().SetProjection or options.FindOne().SetProjection.
+//
+// Example:
+//
+//	opts := options.Find().SetProjection(NewProjection().Include("name", "age").Exclude("_id").Build())
+type Projection struct {
+	elements bson.D
+}
+
+// NewProjection creates an empty Projection.
+func NewProjection() *Projection {
+	return &Projection{
+		elements: make(bson.D, 0),
+	}
+}
+
+// Include adds the given fields to the returned documents.
+func (self *Projection) Include(fields ...string) *Projection {
+	for _, field := range fields {
+		self.elements = append(self.elements, bson.E{Key: field, Value: 1})
+	}
+	return self
+}
+
+// Exclude removes the given fields from the returned documents.
+func (self *Projection) Exclude(fields ...string) *Projection {
+	for _, field := range fields {
+		self.elements = append(self.elements, bson.E{Key: field, Value: 0})
+	}
+	return self
+}
+
+// Slice limits the number of elements returned from an array field.
+// A negative count returns the last elements of the array.
+func (self *Projection) Slice(field string, count int) *Projection {
+	self.elements = append(self.elements, bson.E{Key: field, Value: bson.D{{Key: "$slice", Value: count}}})
+	return self
+}
+
+// Build returns the projection document.
+func (self *Projection) Build() bson.D {
+	return self.elements
+}
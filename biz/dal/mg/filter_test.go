@@ -0,0 +1,162 @@
+package mg
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestFilter_Build(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *Filter
+		expected bson.D
+	}{
+		{
+			name:     "empty",
+			filter:   NewFilter(),
+			expected: bson.D{},
+		},
+		{
+			name:     "nil",
+			filter:   nil,
+			expected: bson.D{},
+		},
+		{
+			name:     "eq",
+			filter:   NewFilter().Eq("status", 1),
+			expected: bson.D{{Key: "status", Value: 1}},
+		},
+		{
+			name:     "eq replaces previous value",
+			filter:   NewFilter().Eq("status", 1).Eq("status", 2),
+			expected: bson.D{{Key: "status", Value: 2}},
+		},
+		{
+			name:   "operators on one field are merged",
+			filter: NewFilter().Gte("age", 18).Lt("age", 60),
+			expected: bson.D{{Key: "age", Value: bson.D{
+				{Key: "$gte", Value: 18},
+				{Key: "$lt", Value: 60},
+			}}},
+		},
+		{
+			name:   "operator after eq replaces the value",
+			filter: NewFilter().Eq("age", 18).Gt("age", 20),
+			expected: bson.D{{Key: "age", Value: bson.D{
+				{Key: "$gt", Value: 20},
+			}}},
+		},
+		{
+			name:     "false condition is skipped",
+			filter:   NewFilter().EqByCondition(false, "status", 1).NeByCondition(false, "name", "a"),
+			expected: bson.D{},
+		},
+		{
+			name:   "in and nin",
+			filter: NewFilter().In("tags", "a", "b").Nin("type", 1),
+			expected: bson.D{
+				{Key: "tags", Value: bson.D{{Key: "$in", Value: bson.A{"a", "b"}}}},
+				{Key: "type", Value: bson.D{{Key: "$nin", Value: bson.A{1}}}},
+			},
+		},
+		{
+			name:   "range",
+			filter: NewFilter().Range("created", 1, 2),
+			expected: bson.D{{Key: "created", Value: bson.D{
+				{Key: "$gte", Value: 1},
+				{Key: "$lte", Value: 2},
+			}}},
+		},
+		{
+			name:   "range with nil lower bound",
+			filter: NewFilter().Range("created", nil, 2),
+			expected: bson.D{{Key: "created", Value: bson.D{
+				{Key: "$lte", Value: 2},
+			}}},
+		},
+		{
+			name:     "range with nil bounds",
+			filter:   NewFilter().Range("created", nil, nil),
+			expected: bson.D{},
+		},
+		{
+			name:   "regex and exists",
+			filter: NewFilter().Regex("name", "^a", "i").Exists("deleted", false),
+			expected: bson.D{
+				{Key: "name", Value: bson.D{{Key: "$regex", Value: bson.Regex{Pattern: "^a", Options: "i"}}}},
+				{Key: "deleted", Value: bson.D{{Key: "$exists", Value: false}}},
+			},
+		},
+		{
+			name:   "elem match",
+			filter: NewFilter().ElemMatch("items", NewFilter().Eq("sku", "x")),
+			expected: bson.D{{Key: "items", Value: bson.D{
+				{Key: "$elemMatch", Value: bson.D{{Key: "sku", Value: "x"}}},
+			}}},
+		},
+		{
+			name:     "empty elem match is skipped",
+			filter:   NewFilter().ElemMatch("items", NewFilter()),
+			expected: bson.D{},
+		},
+		{
+			name:   "or skips empty branches",
+			filter: NewFilter().Or(NewFilter().Eq("a", 1), nil, NewFilter(), NewFilter().Eq("b", 2)),
+			expected: bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "a", Value: 1}},
+				bson.D{{Key: "b", Value: 2}},
+			}}},
+		},
+		{
+			name:     "or without branches",
+			filter:   NewFilter().Or(NewFilter()),
+			expected: bson.D{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.filter.Build()
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestProjection_Build(t *testing.T) {
+	tests := []struct {
+		name       string
+		projection *Projection
+		expected   bson.D
+	}{
+		{
+			name:       "empty",
+			projection: NewProjection(),
+			expected:   bson.D{},
+		},
+		{
+			name:       "include and exclude",
+			projection: NewProjection().Include("name", "age").Exclude("_id"),
+			expected: bson.D{
+				{Key: "name", Value: 1},
+				{Key: "age", Value: 1},
+				{Key: "_id", Value: 0},
+			},
+		},
+		{
+			name:       "slice",
+			projection: NewProjection().Slice("comments", -5),
+			expected:   bson.D{{Key: "comments", Value: bson.D{{Key: "$slice", Value: -5}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.projection.Build()
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package mg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Index drift types reported by DiffIndexes.
+const (
+	IndexMissing    = "missing"    // declared but not present in the collection
+	IndexChanged    = "changed"    // present with a definition that differs from the declaration
+	IndexUndeclared = "undeclared" // present in the collection but not declared
+)
+
+// defaultIndexName is the index MongoDB creates on every collection.
+const defaultIndexName = "_id_"
+
+// ErrIndexDrift is returned by the index diff command when drift is found.
+var ErrIndexDrift = errors.New("mongo index drift detected")
+
+var (
+	indexMutex    sync.Mutex
+	indexRegistry = make(map[string][]*Index)
+)
+
+// Index is a declarative MongoDB index definition built with a fluent API.
+//
+// Example:
+//
+//	mg.RegisterIndexes("users",
+//		mg.NewIndex("uk_email").Asc("email").Unique(),
+//		mg.NewIndex("idx_tenant_created").Asc("tenant_id").Desc("created_at"),
+//		mg.NewIndex("ttl_expire").Asc("expire_at").TTL(0),
+//		mg.NewIndex("txt_title").Text("title", "content"),
+//	)
+type Index struct {
+	name          string
+	keys          bson.D
+	unique        bool
+	sparse        bool
+	ttl           *time.Duration
+	partialFilter bson.D
+	text          bool
+}
+
+// NewIndex creates an index definition. An empty name is replaced by the name
+// MongoDB would generate from the keys, e.g. "tenant_id_1_created_at_-1".
+func NewIndex(name string) *Index {
+	return &Index{
+		name: name,
+		keys: make(bson.D, 0),
+	}
+}
+
+// Asc appends ascending keys to the index.
+func (self *Index) Asc(fields ...string) *Index {
+	for _, field := range fields {
+		self.keys = append(self.keys, bson.E{Key: field, Value: 1})
+	}
+	return self
+}
+
+// Desc appends descending keys to the index.
+func (self *Index) Desc(fields ...string) *Index {
+	for _, field := range fields {
+		self.keys = append(self.keys, bson.E{Key: field, Value: -1})
+	}
+	return self
+}
+
+// Text appends text keys to the index, making it a text index.
+func (self *Index) Text(fields ...string) *Index {
+	for _, field := range fields {
+		self.keys = append(self.keys, bson.E{Key: field, Value: "text"})
+	}
+	self.text = true
+	return self
+}
+
+// Unique rejects documents whose index key duplicates an existing one.
+func (self *Index) Unique() *Index {
+	self.unique = true
+	return self
+}
+
+// Sparse only indexes documents that contain the indexed fields.
+func (self *Index) Sparse() *Index {
+	self.sparse = true
+	return self
+}
+
+// TTL removes documents once the indexed date field is older than expire.
+func (self *Index) TTL(expire time.Duration) *Index {
+	self.ttl = &expire
+	return self
+}
+
+// Partial only indexes documents that match filter.
+func (self *Index) Partial(filter bson.D) *Index {
+	self.partialFilter = filter
+	return self
+}
+
+// Name returns the index name.
+func (self *Index) Name() string {
+	if self.name != "" {
+		return self.name
+	}
+	parts := make([]string, 0, len(self.keys)*2)
+	for _, key := range self.keys {
+		parts = append(parts, key.Key, indexKeyValue(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// model converts the definition into a driver IndexModel.
+func (self *Index) model() mongo.IndexModel {
+	opts := options.Index().SetName(self.Name())
+	if self.unique {
+		opts.SetUnique(true)
+	}
+	if self.sparse {
+		opts.SetSparse(true)
+	}
+	if self.ttl != nil {
+		opts.SetExpireAfterSeconds(int32(self.ttl.Seconds()))
+	}
+	if len(self.partialFilter) > 0 {
+		opts.SetPartialFilterExpression(self.partialFilter)
+	}
+	return mongo.IndexModel{Keys: self.keys, Options: opts}
+}
+
+// RegisterIndexes declares the indexes of collection. It is usually called
+// from an init function next to the model definition, and the declarations
+// are reconciled by InitMongoDB.
+func RegisterIndexes(collection string, indexes ...*Index) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	for _, index := range indexes {
+		if index == nil || len(index.keys) == 0 {
+			continue
+		}
+		indexRegistry[collection] = append(indexRegistry[collection], index)
+	}
+}
+
+// IndexDiff describes a single difference between the declared and the
+// actual indexes of a collection.
+type IndexDiff struct {
+	Collection string
+	Name       string
+	Type       string
+	Detail     string
+}
+
+// existingIndex is the subset of a listIndexes result used for comparison.
+type existingIndex struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.Raw `bson:"key"`
+	Unique                  *bool    `bson:"unique"`
+	Sparse                  *bool    `bson:"sparse"`
+	ExpireAfterSeconds      *int32   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+}
+
+// DiffIndexes compares the declared indexes with the indexes of every
+// collection that has declarations, without changing anything.
+// Collections without declarations are never inspected.
+func DiffIndexes(ctx context.Context) ([]IndexDiff, error) {
+	result := make([]IndexDiff, 0)
+	for _, collection := range declaredCollections() {
+		diffs, err := diffCollectionIndexes(ctx, collection)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, diffs...)
+	}
+	return result, nil
+}
+
+// SyncIndexes creates missing indexes. Changed indexes are only reported unless
+// rebuildChanged is true, since rebuilding drops the index first and leaves the
+// collection without it when the new definition cannot be built. Undeclared
+// indexes are only reported unless dropUndeclared is true. It returns the drift
+// found before reconciling.
+func SyncIndexes(ctx context.Context, dropUndeclared, rebuildChanged bool) ([]IndexDiff, error) {
+	diffs, err := DiffIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, diff := range diffs {
+		view := Collection(diff.Collection).Indexes()
+		switch diff.Type {
+		case IndexMissing:
+			_, err = view.CreateOne(ctx, declaredIndex(diff.Collection, diff.Name).model())
+		case IndexChanged:
+			if !rebuildChanged {
+				slog.Warn("mongo index differs from declaration", slog.Group("data",
+					slog.String("collection", diff.Collection),
+					slog.String("name", diff.Name),
+					slog.String("detail", diff.Detail),
+				))
+				continue
+			}
+			if err = view.DropOne(ctx, diff.Name); err == nil {
+				_, err = view.CreateOne(ctx, declaredIndex(diff.Collection, diff.Name).model())
+			}
+		case IndexUndeclared:
+			if !dropUndeclared {
+				slog.Warn("mongo index is not declared", slog.Group("data",
+					slog.String("collection", diff.Collection),
+					slog.String("name", diff.Name),
+				))
+				continue
+			}
+			err = view.DropOne(ctx, diff.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("mongo index reconciled", slog.Group("data",
+			slog.String("collection", diff.Collection),
+			slog.String("name", diff.Name),
+			slog.String("type", diff.Type),
+			slog.String("detail", diff.Detail),
+		))
+	}
+	return diffs, nil
+}
+
+func declaredCollections() []string {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	collections := make([]string, 0, len(indexRegistry))
+	for collection := range indexRegistry {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	return collections
+}
+
+func declaredIndex(collection, name string) *Index {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	for _, index := range indexRegistry[collection] {
+		if index.Name() == name {
+			return index
+		}
+	}
+	return nil
+}
+
+func diffCollectionIndexes(ctx context.Context, collection string) ([]IndexDiff, error) {
+	cursor, err := Collection(collection).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing := make([]existingIndex, 0)
+	if err = cursor.All(ctx, &existing); err != nil {
+		return nil, err
+	}
+	existingMap := make(map[string]existingIndex, len(existing))
+	for _, index := range existing {
+		existingMap[index.Name] = index
+	}
+
+	indexMutex.Lock()
+	declared := indexRegistry[collection]
+	indexMutex.Unlock()
+
+	diffs := make([]IndexDiff, 0)
+	declaredNames := make(map[string]struct{}, len(declared))
+	for _, index := range declared {
+		name := index.Name()
+		declaredNames[name] = struct{}{}
+		actual, ok := existingMap[name]
+		if !ok {
+			diffs = append(diffs, IndexDiff{Collection: collection, Name: name, Type: IndexMissing, Detail: "create"})
+			continue
+		}
+		detail, err := compareIndex(index, actual)
+		if err != nil {
+			return nil, err
+		}
+		if detail != "" {
+			diffs = append(diffs, IndexDiff{Collection: collection, Name: name, Type: IndexChanged, Detail: detail})
+		}
+	}
+	for _, index := range existing {
+		if _, ok := declaredNames[index.Name]; ok || index.Name == defaultIndexName {
+			continue
+		}
+		diffs = append(diffs, IndexDiff{Collection: collection, Name: index.Name, Type: IndexUndeclared, Detail: "not declared"})
+	}
+	return diffs, nil
+}
+
+// compareIndex returns a description of the differences between the declared
+// and the actual index, or an empty string when they match. Text index keys
+// are stored in an internal form by the server and are therefore not compared.
+func compareIndex(declared *Index, actual existingIndex) (string, error) {
+	differences := make([]string, 0)
+	if !declared.text {
+		keys, err := bson.Marshal(declared.keys)
+		if err != nil {
+			return "", err
+		}
+		if indexKeySignature(keys) != indexKeySignature(actual.Key) {
+			differences = append(differences, "keys "+indexKeySignature(actual.Key)+" -> "+indexKeySignature(keys))
+		}
+	}
+	if declared.unique != (actual.Unique != nil && *actual.Unique) {
+		differences = append(differences, "unique -> "+strconv.FormatBool(declared.unique))
+	}
+	if declared.sparse != (actual.Sparse != nil && *actual.Sparse) {
+		differences = append(differences, "sparse -> "+strconv.FormatBool(declared.sparse))
+	}
+	switch {
+	case declared.ttl == nil && actual.ExpireAfterSeconds != nil:
+		differences = append(differences, "ttl -> none")
+	case declared.ttl != nil && (actual.ExpireAfterSeconds == nil || int64(*actual.ExpireAfterSeconds) != int64(declared.ttl.Seconds())):
+		differences = append(differences, "ttl -> "+declared.ttl.String())
+	}
+	partial, err := partialFilterSignature(declared.partialFilter)
+	if err != nil {
+		return "", err
+	}
+	actualPartial, err := partialFilterSignature(actual.PartialFilterExpression)
+	if err != nil {
+		return "", err
+	}
+	if partial != actualPartial {
+		differences = append(differences, "partial "+actualPartial+" -> "+partial)
+	}
+	return strings.Join(differences, ", "), nil
+}
+
+// indexKeySignature renders a key document as "field:direction,..." so that
+// numeric directions compare equal regardless of their BSON number type.
+func indexKeySignature(keys bson.Raw) string {
+	elements, err := keys.Elements()
+	if err != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(elements))
+	for _, element := range elements {
+		value := element.Value()
+		if number, ok := value.AsInt64OK(); ok {
+			parts = append(parts, element.Key()+":"+strconv.FormatInt(number, 10))
+			continue
+		}
+		parts = append(parts, element.Key()+":"+value.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// partialFilterSignature renders a partial filter as relaxed extended JSON.
+func partialFilterSignature(filter any) (string, error) {
+	switch v := filter.(type) {
+	case bson.D:
+		if len(v) == 0 {
+			return "", nil
+		}
+	case bson.Raw:
+		if len(v) == 0 {
+			return "", nil
+		}
+	}
+	data, err := bson.MarshalExtJSON(filter, false, false)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// indexKeyValue formats a key direction the way MongoDB does in generated index names.
+func indexKeyValue(value any) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case string:
+		return v
+	default:
+		return ""
+	}
+}
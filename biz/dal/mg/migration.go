@@ -0,0 +1,300 @@
+package mg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	migrationCollection = "_migrations"     // migrationCollection records applied migrations and holds the lock.
+	migrationLockID     = "_migration_lock" // migrationLockID is the _id of the lock document.
+	migrationLockTTL    = time.Minute       // migrationLockTTL is how long a lock survives without renewal.
+)
+
+// ErrMigrationLocked is returned when the migration lock cannot be acquired
+// before the lock timeout elapses.
+var ErrMigrationLocked = errors.New("mongo migration lock is held by another process")
+
+// ErrMigrationLockLost is returned when the migration lock could not be renewed
+// before it expired, so another runner may have taken it over.
+var ErrMigrationLockLost = errors.New("mongo migration lock was lost")
+
+var (
+	migrationMutex    sync.Mutex
+	migrationRegistry = make(map[int64]Migration)
+)
+
+// Migration is a versioned, forward-only change to the data or schema of the
+// database. Versions are applied in ascending order and each version is
+// applied once; timestamps such as 202507301200 make convenient versions.
+//
+// Up must tolerate being re-run after a partial failure, since a migration is
+// only recorded once it returns nil.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationState describes a registered migration and when it was applied.
+type MigrationState struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrationRecord is the document stored for every applied migration.
+type migrationRecord struct {
+	Version   int64     `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+	Duration  int64     `bson:"duration_ms"`
+}
+
+// migrationLock is the lock document that serializes concurrent runners.
+type migrationLock struct {
+	ID       string    `bson:"_id"`
+	Owner    string    `bson:"owner"`
+	LockedAt time.Time `bson:"locked_at"`
+	ExpireAt time.Time `bson:"expire_at"`
+}
+
+// RegisterMigrations registers migrations by version. Registering the same
+// version twice panics, because it always indicates a programming error.
+//
+// Example:
+//
+//	func init() {
+//		mg.RegisterMigrations(mg.Migration{
+//			Version: 202507301200,
+//			Name:    "backfill message status",
+//			Up: func(ctx context.Context, db *mongo.Database) error {
+//				_, err := db.Collection("messages").UpdateMany(ctx,
+//					mg.NewFilter().Exists("status", false).Build(),
+//					bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: 1}}}},
+//				)
+//				return err
+//			},
+//		})
+//	}
+func RegisterMigrations(migrations ...Migration) {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+	for _, migration := range migrations {
+		if migration.Up == nil {
+			continue
+		}
+		if _, ok := migrationRegistry[migration.Version]; ok {
+			panic("duplicate mongo migration version " + strconv.FormatInt(migration.Version, 10))
+		}
+		migrationRegistry[migration.Version] = migration
+	}
+}
+
+// registeredMigrations returns the registered migrations in ascending version order.
+func registeredMigrations() []Migration {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+	migrations := make([]Migration, 0, len(migrationRegistry))
+	for _, migration := range migrationRegistry {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations
+}
+
+// MigrationStatus returns every registered migration and whether it has been applied.
+func MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations := registeredMigrations()
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		record, ok := applied[migration.Version]
+		states = append(states, MigrationState{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return states, nil
+}
+
+// Migrate applies every pending migration in version order while holding the
+// migration lock, and returns the versions it applied. It stops at the first
+// failing migration. lockTimeout bounds how long to wait for another runner
+// to release the lock. When the lock is lost while migrating, the running
+// migration is canceled and Migrate returns ErrMigrationLockLost.
+func Migrate(ctx context.Context, lockTimeout time.Duration) ([]int64, error) {
+	ctx, release, err := acquireMigrationLock(ctx, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0)
+	for _, migration := range registeredMigrations() {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err = context.Cause(ctx); err != nil {
+			return versions, err
+		}
+		begin := time.Now()
+		if err = migration.Up(ctx, mongoDB); err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, ErrMigrationLockLost) {
+				err = cause
+			}
+			slog.Error("mongo migration failed", slog.Group("data",
+				slog.Int64("version", migration.Version),
+				slog.String("name", migration.Name),
+			), slog.String("error", err.Error()))
+			return versions, err
+		}
+		record := migrationRecord{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+			Duration:  time.Since(begin).Milliseconds(),
+		}
+		if _, err = Collection(migrationCollection).InsertOne(ctx, record); err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, ErrMigrationLockLost) {
+				err = cause
+			}
+			return versions, err
+		}
+		slog.Info("mongo migration applied", slog.Group("data",
+			slog.Int64("version", migration.Version),
+			slog.String("name", migration.Name),
+			slog.Int64("duration", record.Duration),
+		))
+		versions = append(versions, migration.Version)
+	}
+	return versions, nil
+}
+
+func appliedMigrations(ctx context.Context) (map[int64]migrationRecord, error) {
+	cursor, err := Collection(migrationCollection).Find(ctx, NewFilter().Ne("_id", migrationLockID).Build())
+	if err != nil {
+		return nil, err
+	}
+	records, err := CursorToSlice[migrationRecord](ctx, cursor)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64]migrationRecord, len(records))
+	for _, record := range records {
+		result[record.Version] = record
+	}
+	return result, nil
+}
+
+// acquireMigrationLock inserts the lock document, waiting while another owner
+// holds it. A lock whose expire_at has passed is treated as abandoned by a
+// crashed runner and is taken over, so the holder keeps extending expire_at
+// in the background. The returned context is canceled with ErrMigrationLockLost
+// once the lock is lost, and the returned function releases the lock.
+func acquireMigrationLock(ctx context.Context, timeout time.Duration) (context.Context, func(), error) {
+	hostname, _ := os.Hostname()
+	owner := hostname + "-" + uuid.NewString()
+	deadline := time.Now().Add(timeout)
+	collection := Collection(migrationCollection)
+	for {
+		now := time.Now()
+		_, err := collection.InsertOne(ctx, migrationLock{
+			ID:       migrationLockID,
+			Owner:    owner,
+			LockedAt: now,
+			ExpireAt: now.Add(migrationLockTTL),
+		})
+		if err == nil {
+			slog.Info("mongo migration lock acquired", slog.String("owner", owner))
+			lockCtx, release := holdMigrationLock(ctx, owner, now.Add(migrationLockTTL))
+			return lockCtx, release, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, err
+		}
+		_, err = collection.DeleteOne(ctx, NewFilter().Eq("_id", migrationLockID).Lt("expire_at", now).Build())
+		if err != nil {
+			return nil, nil, err
+		}
+		if now.After(deadline) {
+			return nil, nil, ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// holdMigrationLock renews the lock owned by owner, which expires at expireAt,
+// until the returned release function is called, which stops the renewal and
+// deletes the lock. The returned context, derived from ctx, is canceled with
+// ErrMigrationLockLost when a renewal matches no lock because it expired and
+// was taken over, or when renewals keep failing until the lock expires.
+func holdMigrationLock(ctx context.Context, owner string, expireAt time.Time) (context.Context, func()) {
+	lockCtx, cancelLock := context.WithCancelCause(ctx)
+	renewCtx, stopRenew := context.WithCancel(context.WithoutCancel(ctx))
+	filter := NewFilter().Eq("_id", migrationLockID).Eq("owner", owner).Build()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(migrationLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				next := time.Now().Add(migrationLockTTL)
+				update := bson.D{{Key: "$set", Value: bson.D{{Key: "expire_at", Value: next}}}}
+				result, err := Collection(migrationCollection).UpdateOne(renewCtx, filter, update)
+				switch {
+				case err != nil && renewCtx.Err() != nil:
+					return
+				case err != nil:
+					slog.Error("renew mongo migration lock failed", slog.String("error", err.Error()))
+					if time.Now().Before(expireAt) {
+						continue
+					}
+				case result.MatchedCount > 0:
+					expireAt = next
+					continue
+				}
+				slog.Error("mongo migration lock lost, aborting migrations", slog.String("owner", owner))
+				cancelLock(ErrMigrationLockLost)
+				return
+			}
+		}
+	}()
+	return lockCtx, func() {
+		stopRenew()
+		<-done
+		cancelLock(nil)
+		if _, err := Collection(migrationCollection).DeleteOne(context.Background(), filter); err != nil {
+			slog.Error("release mongo migration lock failed", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -3,13 +3,17 @@ package mg
 import (
 	"context"
 	"log/slog"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/spf13/viper"
 	"github.com/wnnce/fserv-template/config"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readconcern"
 	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
 )
 
 var (
@@ -19,18 +23,56 @@ var (
 	mutex         sync.Mutex                   // mutex ensures safe concurrent access to collectionMap.
 )
 
-// InitMongoDB initializes a MongoDB client using configuration loaded from Viper.
-// It validates the connection with a ping and caches the connected database.
+// InitMongoDB initializes a MongoDB client using configuration loaded from Viper,
+// reconciles the indexes declared with RegisterIndexes and optionally applies
+// pending migrations.
+//
+// Index reconciliation is controlled by mongo.indexes.sync (default true): missing
+// indexes are created, changed ones are only reported, and indexes that are no
+// longer declared are dropped only when mongo.indexes.drop-undeclared is true.
+// Changed indexes are rebuilt with the `mongo indexes sync --rebuild-changed` command. Migrations run at boot only when
+// mongo.migration.auto is true.
 //
 // Returns a cleanup function to disconnect the client and clear internal caches,
-// or an error if the connection or ping fails.
+// or an error if the connection, ping, index reconciliation or a migration fails.
 func InitMongoDB(ctx context.Context) (func(), error) {
+	cleanup, err := ConnectMongoDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if config.ViperGet[bool]("mongo.indexes.sync", true) {
+		if _, err = SyncIndexes(ctx, config.ViperGet[bool]("mongo.indexes.drop-undeclared", false), false); err != nil {
+			slog.Error("mongoDB index sync failed", slog.String("error", err.Error()))
+			cleanup()
+			return nil, err
+		}
+	}
+	if config.ViperGet[bool]("mongo.migration.auto", false) {
+		if _, err = Migrate(ctx, config.ViperGet[time.Duration]("mongo.migration.lock-timeout", time.Minute)); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	return cleanup, nil
+}
+
+// ConnectMongoDB connects the MongoDB client using configuration loaded from Viper.
+// It validates the connection with a ping and caches the connected database,
+// without touching any indexes.
+//
+// Returns a cleanup function to disconnect the client and clear internal caches,
+// or an error if the connection or ping fails.
+func ConnectMongoDB(ctx context.Context) (func(), error) {
 	mongoURL := config.ViperGet[string]("mongo.url", "mongodb://127.0.0.1:27017")
-	client, err := mongo.Connect(options.Client().ApplyURI(mongoURL))
+	opts, err := clientOptions(mongoURL)
 	if err != nil {
 		return nil, err
 	}
-	timeout, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	client, err := mongo.Connect(opts)
+	if err != nil {
+		return nil, err
+	}
+	timeout, cancel := context.WithTimeout(context.Background(), config.ViperGet[time.Duration]("mongo.ping-timeout", 2*time.Second))
 	defer cancel()
 	if err = client.Ping(timeout, readpref.Primary()); err != nil {
 		slog.Error("mongoDB ping failed", slog.String("error", err.Error()))
@@ -47,6 +89,71 @@ func InitMongoDB(ctx context.Context) (func(), error) {
 	}, nil
 }
 
+// clientOptions builds the client options from the URI and the mongo.*
+// configuration. Options that are not configured keep the URI or driver defaults.
+func clientOptions(mongoURL string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(mongoURL).
+		SetAppName(config.ViperGet[string]("server.name", "fserv-template")).
+		SetMonitor(newCommandMonitor(config.ViperGet[time.Duration]("mongo.slow-threshold", 500*time.Millisecond)))
+	if viper.IsSet("mongo.max-pool-size") {
+		opts.SetMaxPoolSize(config.ViperGet[uint64]("mongo.max-pool-size"))
+	}
+	if viper.IsSet("mongo.min-pool-size") {
+		opts.SetMinPoolSize(config.ViperGet[uint64]("mongo.min-pool-size"))
+	}
+	if viper.IsSet("mongo.max-connecting") {
+		opts.SetMaxConnecting(config.ViperGet[uint64]("mongo.max-connecting"))
+	}
+	if viper.IsSet("mongo.max-conn-idle-time") {
+		opts.SetMaxConnIdleTime(config.ViperGet[time.Duration]("mongo.max-conn-idle-time"))
+	}
+	if viper.IsSet("mongo.connect-timeout") {
+		opts.SetConnectTimeout(config.ViperGet[time.Duration]("mongo.connect-timeout"))
+	}
+	if viper.IsSet("mongo.server-selection-timeout") {
+		opts.SetServerSelectionTimeout(config.ViperGet[time.Duration]("mongo.server-selection-timeout"))
+	}
+	if viper.IsSet("mongo.timeout") {
+		opts.SetTimeout(config.ViperGet[time.Duration]("mongo.timeout"))
+	}
+	if compressors := config.ViperGet[[]string]("mongo.compressors"); len(compressors) > 0 {
+		opts.SetCompressors(compressors)
+	}
+	if mode := config.ViperGet[string]("mongo.read-preference"); mode != "" {
+		readMode, err := readpref.ModeFromString(mode)
+		if err != nil {
+			return nil, err
+		}
+		readPreference, err := readpref.New(readMode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(readPreference)
+	}
+	if level := config.ViperGet[string]("mongo.read-concern"); level != "" {
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: level})
+	}
+	if w := config.ViperGet[string]("mongo.write-concern.w"); w != "" {
+		writeConcern := &writeconcern.WriteConcern{W: w}
+		if number, err := strconv.Atoi(w); err == nil {
+			writeConcern.W = number
+		}
+		if viper.IsSet("mongo.write-concern.journal") {
+			journal := config.ViperGet[bool]("mongo.write-concern.journal")
+			writeConcern.Journal = &journal
+		}
+		opts.SetWriteConcern(writeConcern)
+	}
+	tlsConfig, err := config.ViperTLSConfig("mongo.tls")
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, nil
+}
+
 // Database returns the connected MongoDB database instance.
 // Panics if the database has not been initialized.
 func Database() *mongo.Database {
@@ -0,0 +1,104 @@
+package mg
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// maxLoggedCommandLength caps the size of a command logged as slow.
+const maxLoggedCommandLength = 1024
+
+// startedCommand keeps the top-level fields of a running command that fit in
+// maxLoggedCommandLength; larger fields, such as the documents of an insert,
+// are only recorded by key and size.
+type startedCommand struct {
+	elements []bson.RawElement
+	omitted  []string
+}
+
+// newCommandMonitor returns a command monitor that logs every command taking
+// at least threshold, together with a size-capped rendering of its command
+// document. The log records use the operation context, so the request traceId
+// is attached by the logger. A non-positive threshold disables the monitor.
+func newCommandMonitor(threshold time.Duration) *event.CommandMonitor {
+	if threshold <= 0 {
+		return nil
+	}
+	// started keeps the capped command of each request ID until the command
+	// finishes; it is only rendered when the command turns out slow.
+	started := &sync.Map{}
+	finished := func(ctx context.Context, finished event.CommandFinishedEvent, err error) {
+		value, _ := started.LoadAndDelete(finished.RequestID)
+		if finished.Duration < threshold {
+			return
+		}
+		command, _ := value.(*startedCommand)
+		attrs := []any{
+			slog.Group("data",
+				slog.String("command", finished.CommandName),
+				slog.String("database", finished.DatabaseName),
+				slog.Duration("duration", finished.Duration),
+				slog.String("document", command.String()),
+			),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		slog.WarnContext(ctx, "mongo slow command", attrs...)
+	}
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, startedEvent *event.CommandStartedEvent) {
+			started.Store(startedEvent.RequestID, captureCommand(startedEvent.Command))
+		},
+		Succeeded: func(ctx context.Context, succeededEvent *event.CommandSucceededEvent) {
+			finished(ctx, succeededEvent.CommandFinishedEvent, nil)
+		},
+		Failed: func(ctx context.Context, failedEvent *event.CommandFailedEvent) {
+			finished(ctx, failedEvent.CommandFinishedEvent, failedEvent.Failure)
+		},
+	}
+}
+
+// captureCommand copies the top-level fields of command while they fit in
+// maxLoggedCommandLength, without copying or rendering the larger ones.
+func captureCommand(command bson.Raw) *startedCommand {
+	result := &startedCommand{}
+	elements, err := command.Elements()
+	if err != nil {
+		return result
+	}
+	size := 0
+	for _, element := range elements {
+		if size+len(element) > maxLoggedCommandLength {
+			result.omitted = append(result.omitted, element.Key()+" ("+strconv.Itoa(len(element))+" bytes)")
+			continue
+		}
+		size += len(element)
+		result.elements = append(result.elements, bytes.Clone(element))
+	}
+	return result
+}
+
+// String renders the captured fields as extended JSON, followed by the keys of
+// the omitted ones.
+func (self *startedCommand) String() string {
+	if self == nil {
+		return ""
+	}
+	fields := make([]string, 0, len(self.elements)+1)
+	for _, element := range self.elements {
+		fields = append(fields, element.String())
+	}
+	if len(self.omitted) > 0 {
+		fields = append(fields, `"omitted": "`+strings.Join(self.omitted, ", ")+`"`)
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
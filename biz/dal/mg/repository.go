@@ -0,0 +1,247 @@
+package mg
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/wnnce/fserv-template/biz/dal/db"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrEmptyUpdate is returned by UpdateByID when the given value has no non-zero fields.
+var ErrEmptyUpdate = errors.New("mongo update document has no non-zero fields")
+
+// Repository provides typed CRUD access to a single MongoDB collection.
+// T is the document model and is decoded with the standard bson struct tags.
+//
+// Example:
+//
+//	var userRepo = mg.NewRepository[User]("users")
+//
+//	user, err := userRepo.FindByID(ctx, id)
+//	page, err := userRepo.Page(ctx, mg.NewFilter().Eq("status", 1).Build(), 1, 20, true)
+type Repository[T any] struct {
+	name string
+}
+
+// NewRepository creates a Repository bound to the named collection.
+// The collection is resolved lazily, so repositories can be declared as
+// package variables before InitMongoDB runs.
+func NewRepository[T any](name string) *Repository[T] {
+	return &Repository[T]{name: name}
+}
+
+// Collection returns the underlying cached *mongo.Collection.
+func (self *Repository[T]) Collection() *mongo.Collection {
+	return Collection(self.name)
+}
+
+// FindByID returns the document whose _id equals id.
+// It returns mongo.ErrNoDocuments if no document matches.
+func (self *Repository[T]) FindByID(ctx context.Context, id any, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	return self.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, opts...)
+}
+
+// FindOne returns the first document that matches filter.
+// It returns mongo.ErrNoDocuments if no document matches.
+func (self *Repository[T]) FindOne(ctx context.Context, filter any, opts ...options.Lister[options.FindOneOptions]) (*T, error) {
+	var row T
+	if err := self.Collection().FindOne(ctx, normalizeFilter(filter), opts...).Decode(&row); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// Find returns every document that matches filter.
+func (self *Repository[T]) Find(ctx context.Context, filter any, opts ...options.Lister[options.FindOptions]) ([]*T, error) {
+	cursor, err := self.Collection().Find(ctx, normalizeFilter(filter), opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	return CursorToAddrSlice[T](ctx, cursor)
+}
+
+// Page returns one page of documents matching filter in the same shape as
+// db.SelectPage. When safe is true, a page beyond the last one is clamped to
+// the last page. A non-positive size returns an empty page. Sort and
+// projection can be supplied through opts; skip and limit are always overridden.
+func (self *Repository[T]) Page(
+	ctx context.Context,
+	filter any,
+	page, size int,
+	safe bool,
+	opts ...options.Lister[options.FindOptions],
+) (*db.PageData[T], error) {
+	filter = normalizeFilter(filter)
+	total, err := self.Collection().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 || size <= 0 {
+		return &db.PageData[T]{
+			Current: page,
+			Size:    size,
+			Total:   total,
+			Pages:   0,
+			Records: make([]*T, 0),
+		}, nil
+	}
+	offset := db.ComputeOffset(total, page, size, safe)
+	opts = append(opts, options.Find().SetSkip(offset).SetLimit(int64(size)))
+	records, err := self.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	pages := int(math.Ceil(float64(total) / float64(size)))
+	if page > pages && safe {
+		page = pages
+	}
+	return &db.PageData[T]{
+		Current: page,
+		Size:    size,
+		Total:   total,
+		Pages:   pages,
+		Records: records,
+	}, nil
+}
+
+// Count returns the number of documents that match filter.
+func (self *Repository[T]) Count(ctx context.Context, filter any) (int64, error) {
+	return self.Collection().CountDocuments(ctx, normalizeFilter(filter))
+}
+
+// InsertOne inserts a document and returns its _id.
+func (self *Repository[T]) InsertOne(ctx context.Context, document *T) (any, error) {
+	result, err := self.Collection().InsertOne(ctx, document)
+	if err != nil {
+		return nil, err
+	}
+	return result.InsertedID, nil
+}
+
+// InsertMany inserts the documents in order and returns their _id values.
+func (self *Repository[T]) InsertMany(ctx context.Context, documents []*T) ([]any, error) {
+	if len(documents) == 0 {
+		return make([]any, 0), nil
+	}
+	result, err := self.Collection().InsertMany(ctx, documents)
+	if err != nil {
+		return nil, err
+	}
+	return result.InsertedIDs, nil
+}
+
+// UpdateByID applies a $set containing every non-zero field of value to the
+// document whose _id equals id, and returns the number of modified documents.
+// The _id field of value is never part of the update.
+func (self *Repository[T]) UpdateByID(ctx context.Context, id any, value *T) (int64, error) {
+	fields, err := NonZeroFields(value)
+	if err != nil {
+		return 0, err
+	}
+	if len(fields) == 0 {
+		return 0, ErrEmptyUpdate
+	}
+	result, err := self.Collection().UpdateByID(ctx, id, bson.D{{Key: "$set", Value: fields}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// DeleteByID deletes the document whose _id equals id and returns the number
+// of deleted documents.
+func (self *Repository[T]) DeleteByID(ctx context.Context, id any) (int64, error) {
+	result, err := self.Collection().DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// normalizeFilter builds a *Filter into its document and replaces a nil filter
+// with an empty document, which the driver requires to match every document.
+func normalizeFilter(filter any) any {
+	switch v := filter.(type) {
+	case nil:
+		return bson.D{}
+	case *Filter:
+		return v.Build()
+	default:
+		return filter
+	}
+}
+
+// NonZeroFields converts a struct (or pointer to struct) into a bson.D that
+// contains only its non-zero fields, keyed by their bson tag names.
+// Fields tagged "-" and the _id field are skipped, and ",inline" structs are
+// flattened into the result. It is mainly used to build partial $set updates.
+func NonZeroFields(value any) (bson.D, error) {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, errors.New("mongo update value is nil")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("mongo update value must be a struct, got " + rv.Kind().String())
+	}
+	result := make(bson.D, 0, rv.NumField())
+	appendNonZeroFields(rv, &result)
+	return result, nil
+}
+
+func appendNonZeroFields(rv reflect.Value, result *bson.D) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, inline := parseBsonTag(field)
+		if name == "-" {
+			continue
+		}
+		value := rv.Field(i)
+		if inline {
+			for value.Kind() == reflect.Pointer && !value.IsNil() {
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				appendNonZeroFields(value, result)
+			}
+			continue
+		}
+		if name == "_id" || value.IsZero() {
+			continue
+		}
+		*result = append(*result, bson.E{Key: name, Value: value.Interface()})
+	}
+}
+
+// parseBsonTag returns the document key for field following the driver's
+// default struct codec rules, and whether the field is inlined.
+func parseBsonTag(field reflect.StructField) (string, bool) {
+	tag, ok := field.Tag.Lookup("bson")
+	if !ok {
+		return strings.ToLower(field.Name), false
+	}
+	name, flags, _ := strings.Cut(tag, ",")
+	inline := false
+	for _, flag := range strings.Split(flags, ",") {
+		if flag == "inline" {
+			inline = true
+		}
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, inline
+}
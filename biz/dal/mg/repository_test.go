@@ -0,0 +1,92 @@
+package mg
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type nonZeroBase struct {
+	CreatedBy string `bson:"created_by"`
+}
+
+type nonZeroDocument struct {
+	ID       bson.ObjectID `bson:"_id,omitempty"`
+	Name     string        `bson:"name"`
+	Age      int           `bson:"age,omitempty"`
+	Nickname string
+	Ignored  string       `bson:"-"`
+	Tags     []string     `bson:"tags"`
+	Base     nonZeroBase  `bson:",inline"`
+	Extra    *nonZeroBase `bson:",inline"`
+	private  string
+}
+
+func TestNonZeroFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		expected bson.D
+	}{
+		{
+			name:     "zero struct",
+			value:    nonZeroDocument{},
+			expected: bson.D{},
+		},
+		{
+			name: "skips id, ignored, unexported and zero fields",
+			value: &nonZeroDocument{
+				ID:      bson.NewObjectID(),
+				Name:    "a",
+				Ignored: "x",
+				private: "y",
+			},
+			expected: bson.D{{Key: "name", Value: "a"}},
+		},
+		{
+			name: "untagged field uses the lower-cased name",
+			value: nonZeroDocument{
+				Nickname: "n",
+				Age:      3,
+			},
+			expected: bson.D{
+				{Key: "age", Value: 3},
+				{Key: "nickname", Value: "n"},
+			},
+		},
+		{
+			name: "inline structs are flattened",
+			value: nonZeroDocument{
+				Tags:  []string{"t"},
+				Base:  nonZeroBase{CreatedBy: "u"},
+				Extra: &nonZeroBase{CreatedBy: "v"},
+			},
+			expected: bson.D{
+				{Key: "tags", Value: []string{"t"}},
+				{Key: "created_by", Value: "u"},
+				{Key: "created_by", Value: "v"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NonZeroFields(tt.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestNonZeroFields_Invalid(t *testing.T) {
+	var nilDocument *nonZeroDocument
+	for _, value := range []any{nilDocument, 1, "a"} {
+		if _, err := NonZeroFields(value); err == nil {
+			t.Errorf("expected an error for %T", value)
+		}
+	}
+}
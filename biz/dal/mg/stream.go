@@ -0,0 +1,524 @@
+package mg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wnnce/fserv-template/config"
+	"github.com/wnnce/fserv-template/pkg/tool"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Change stream operation types.
+const (
+	OperationInsert  = "insert"
+	OperationUpdate  = "update"
+	OperationReplace = "replace"
+	OperationDelete  = "delete"
+)
+
+// Server error codes meaning the stored resume token can no longer be used.
+const (
+	errorCodeInvalidResumeToken      = 260
+	errorCodeChangeStreamFatal       = 280
+	errorCodeChangeStreamHistoryLost = 286
+)
+
+// Namespace identifies the database and collection a change event belongs to.
+type Namespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+// UpdateDescription lists the fields changed by an update operation.
+type UpdateDescription struct {
+	UpdatedFields bson.Raw `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// ChangeEvent is a single change stream event. FullDocument is populated for
+// inserts and replaces, and for updates via an update lookup.
+type ChangeEvent struct {
+	ID                bson.Raw           `bson:"_id"`
+	OperationType     string             `bson:"operationType"`
+	Namespace         Namespace          `bson:"ns"`
+	DocumentKey       bson.Raw           `bson:"documentKey"`
+	FullDocument      bson.Raw           `bson:"fullDocument"`
+	UpdateDescription *UpdateDescription `bson:"updateDescription"`
+	ClusterTime       bson.Timestamp     `bson:"clusterTime"`
+	WallTime          time.Time          `bson:"wallTime"`
+}
+
+// ChangeHandler processes a change event. A returned error is logged and the
+// event is considered handled, so handlers should retry internally when needed.
+type ChangeHandler func(ctx context.Context, event *ChangeEvent) error
+
+// TypedChangeHandler adapts a handler that works on decoded documents into a
+// ChangeHandler. document is nil when the event carries no full document,
+// which is always the case for deletes.
+func TypedChangeHandler[T any](handler func(ctx context.Context, event *ChangeEvent, document *T) error) ChangeHandler {
+	return func(ctx context.Context, event *ChangeEvent) error {
+		var document *T
+		if len(event.FullDocument) > 0 {
+			document = new(T)
+			if err := bson.Unmarshal(event.FullDocument, document); err != nil {
+				return err
+			}
+		}
+		return handler(ctx, event, document)
+	}
+}
+
+// ChangeWatcher binds a handler to the change events of one collection.
+// An empty Operations list accepts every operation type.
+type ChangeWatcher struct {
+	Collection string
+	Operations []string
+	Handler    ChangeHandler
+}
+
+func NewChangeWatcher(collection string, handler ChangeHandler, operations ...string) ChangeWatcher {
+	return ChangeWatcher{
+		Collection: collection,
+		Operations: operations,
+		Handler:    handler,
+	}
+}
+
+// accept reports whether the watcher is interested in the given operation.
+func (self *ChangeWatcher) accept(operation string) bool {
+	return len(self.Operations) == 0 || slices.Contains(self.Operations, operation)
+}
+
+// Watchable is implemented by *mongo.Database and *mongo.Collection.
+type Watchable interface {
+	Watch(ctx context.Context, pipeline any, opts ...options.Lister[options.ChangeStreamOptions]) (*mongo.ChangeStream, error)
+}
+
+// changeTask is an event queued to a collection worker together with its
+// position in the token tracker.
+type changeTask struct {
+	event *ChangeEvent
+	entry *pendingToken
+}
+
+// changeWorker holds the context and queue of a single collection worker.
+type changeWorker struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	collection string
+	ch         chan *changeTask
+	removed    atomic.Bool
+}
+
+// ChangeStreamService watches a database or collection and dispatches change
+// events to registered watchers. Each collection is handled by its own worker
+// goroutine, so events of one collection are processed in order.
+//
+// Resume tokens are saved to the ResumeTokenStore only once every earlier
+// event has been handled, which gives at-least-once delivery across restarts.
+type ChangeStreamService struct {
+	running       atomic.Bool
+	name          string
+	target        Watchable
+	store         ResumeTokenStore
+	flushInterval time.Duration
+	mutex         *sync.Mutex
+	watchers      map[string]*ChangeWatcher
+	workerMap     map[string]*changeWorker
+	workerMutex   *sync.Mutex
+	workerGroup   *sync.WaitGroup
+	tracker       *tokenTracker
+	// ignored collections are filtered out of the stream by the server.
+	ignored []string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// NewChangeStreamService creates a ChangeStreamService named name that watches
+// target and persists its resume token in store under that name. The collection
+// of a MongoTokenStore is never watched, since every saved token would otherwise
+// produce another event when the whole database is watched.
+func NewChangeStreamService(ctx context.Context, name string, target Watchable, store ResumeTokenStore) *ChangeStreamService {
+	childCtx, cancel := context.WithCancel(ctx)
+	service := &ChangeStreamService{
+		name:          name,
+		target:        target,
+		store:         store,
+		flushInterval: time.Second,
+		mutex:         &sync.Mutex{},
+		watchers:      make(map[string]*ChangeWatcher),
+		workerMap:     make(map[string]*changeWorker),
+		workerMutex:   &sync.Mutex{},
+		workerGroup:   &sync.WaitGroup{},
+		tracker:       &tokenTracker{},
+		ctx:           childCtx,
+		cancel:        cancel,
+	}
+	if tokenStore, ok := store.(*MongoTokenStore); ok {
+		service.ignored = []string{tokenStore.collection}
+	}
+	return service
+}
+
+// RegisterWatchers registers one or more watchers. A collection can only have
+// one watcher; later registrations for the same collection are ignored.
+func (self *ChangeStreamService) RegisterWatchers(watchers ...ChangeWatcher) {
+	if self.ctx.Err() != nil || len(watchers) == 0 {
+		return
+	}
+	self.mutex.Lock()
+	for _, watcher := range watchers {
+		if strings.TrimSpace(watcher.Collection) == "" || watcher.Handler == nil {
+			continue
+		}
+		if _, ok := self.watchers[watcher.Collection]; !ok {
+			self.watchers[watcher.Collection] = &watcher
+		}
+	}
+	self.mutex.Unlock()
+}
+
+// RemoveWatchers removes the watchers of the given collections. Queued events
+// of removed collections are dropped and no longer hold back the resume token.
+func (self *ChangeStreamService) RemoveWatchers(collections ...string) {
+	if self.ctx.Err() != nil || len(collections) == 0 {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.workerMutex.Lock()
+	defer self.workerMutex.Unlock()
+	for _, collection := range collections {
+		delete(self.watchers, collection)
+		if worker, ok := self.workerMap[collection]; ok {
+			worker.removed.Store(true)
+			worker.cancel()
+			delete(self.workerMap, collection)
+		}
+	}
+}
+
+// ReadLoop opens the change stream and dispatches events until the service is
+// shut down. Stream errors are retried with backoff, resuming from the last
+// received event; a token that is no longer valid is discarded and the stream
+// restarts from the current time.
+func (self *ChangeStreamService) ReadLoop() {
+	if !self.running.CompareAndSwap(false, true) {
+		return
+	}
+	defer self.running.Store(false)
+	token, err := self.store.Load(self.ctx, self.name)
+	if err != nil {
+		slog.Error("load change stream resume token failed", slog.String("name", self.name), slog.String("error", err.Error()))
+	}
+	go self.flushLoop()
+	backoff := time.Second
+	for {
+		if self.ctx.Err() != nil {
+			slog.Info("change stream service context is canceled, service exit", slog.String("name", self.name))
+			return
+		}
+		self.mutex.Lock()
+		empty := len(self.watchers) == 0
+		self.mutex.Unlock()
+		if empty {
+			slog.Error("change stream service exit, watchers is empty", slog.String("name", self.name))
+			return
+		}
+		var received bool
+		token, received, err = self.watch(token)
+		if err == nil || self.ctx.Err() != nil {
+			continue
+		}
+		if isResumeTokenLost(err) {
+			slog.Error("change stream resume token is no longer valid, restart from now",
+				slog.String("name", self.name), slog.String("error", err.Error()))
+			token = nil
+			if err = self.store.Delete(self.ctx, self.name); err != nil {
+				slog.Error("delete change stream resume token failed", slog.String("name", self.name), slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if received {
+			backoff = time.Second
+		}
+		slog.Error("change stream watch error, reconnecting", slog.String("name", self.name),
+			slog.Duration("backoff", backoff), slog.String("error", err.Error()))
+		select {
+		case <-self.ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// watch opens a change stream resuming after token and dispatches events until
+// the stream fails or the service is canceled. It returns the token of the last
+// received event and whether any event was received.
+func (self *ChangeStreamService) watch(token bson.Raw) (bson.Raw, bool, error) {
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(token) > 0 {
+		opts.SetResumeAfter(token)
+	}
+	pipeline := mongo.Pipeline{}
+	if len(self.ignored) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: "ns.coll", Value: bson.D{{Key: "$nin", Value: self.ignored}}},
+		}}})
+	}
+	stream, err := self.target.Watch(self.ctx, pipeline, opts)
+	if err != nil {
+		return token, false, err
+	}
+	defer func() {
+		_ = stream.Close(context.Background())
+	}()
+	slog.Info("change stream opened", slog.String("name", self.name), slog.Bool("resume", len(token) > 0))
+	received := false
+	for stream.Next(self.ctx) {
+		event := &ChangeEvent{}
+		if err = stream.Decode(event); err != nil {
+			return token, received, err
+		}
+		received = true
+		token = stream.ResumeToken()
+		self.dispatch(event, token)
+	}
+	if self.ctx.Err() != nil {
+		return token, received, nil
+	}
+	return token, received, stream.Err()
+}
+
+// dispatch queues event to the worker of its collection, creating the worker
+// on first use. Events without a matching watcher are marked handled at once.
+//
+// The watcher lookup, the worker creation and the send hold the watcher mutex,
+// so RemoveWatchers cannot interleave: a removed watcher never gets a new
+// worker, and a queued event is either handled or dropped by its worker.
+func (self *ChangeStreamService) dispatch(event *ChangeEvent, token bson.Raw) {
+	entry := self.tracker.add(token)
+	collection := event.Namespace.Collection
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	watcher, ok := self.watchers[collection]
+	if !ok || !watcher.accept(event.OperationType) {
+		self.tracker.done(entry)
+		return
+	}
+	self.workerMutex.Lock()
+	worker, ok := self.workerMap[collection]
+	if !ok {
+		if self.ctx.Err() != nil {
+			// Shutdown is waiting for the workers, so no new one may start. The
+			// event stays pending and is delivered again after a restart.
+			self.workerMutex.Unlock()
+			return
+		}
+		ctx, cancel := context.WithCancel(self.ctx)
+		worker = &changeWorker{
+			ctx:        ctx,
+			cancel:     cancel,
+			collection: collection,
+			ch:         make(chan *changeTask, 256),
+		}
+		self.workerMap[collection] = worker
+		self.workerGroup.Add(1)
+		go self.worker(worker, watcher)
+	}
+	self.workerMutex.Unlock()
+	tool.SafeSendWithCallback(worker.ctx, worker.ch, &changeTask{event: event, entry: entry}, func(err error) {
+		// on shutdown the event stays pending, so it is delivered again after a restart.
+		if self.ctx.Err() == nil {
+			self.tracker.done(entry)
+		}
+	})
+}
+
+// worker handles the events of a single collection in order.
+func (self *ChangeStreamService) worker(worker *changeWorker, watcher *ChangeWatcher) {
+	defer func() {
+		worker.cancel()
+		// events of a removed watcher are dropped on purpose and must not
+		// hold back the resume token; on shutdown they are left unhandled.
+		if worker.removed.Load() {
+			for len(worker.ch) > 0 {
+				self.tracker.done((<-worker.ch).entry)
+			}
+		}
+		self.workerGroup.Done()
+	}()
+	for {
+		select {
+		case <-worker.ctx.Done():
+			slog.Info("change stream worker exit is context canceled", slog.String("collection", worker.collection))
+			return
+		case task := <-worker.ch:
+			self.handle(worker.ctx, watcher, task.event)
+			self.tracker.done(task.entry)
+		}
+	}
+}
+
+// handle invokes the watcher handler, logging returned errors and recovering panics.
+func (self *ChangeStreamService) handle(ctx context.Context, watcher *ChangeWatcher, event *ChangeEvent) {
+	defer func() {
+		if value := recover(); value != nil {
+			slog.ErrorContext(ctx, "change stream handler panic recovered",
+				slog.String("collection", watcher.Collection),
+				slog.Any("error", value),
+				slog.String("debug", string(debug.Stack())),
+			)
+		}
+	}()
+	if err := watcher.Handler(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "change stream handler error", slog.Group("data",
+			slog.String("collection", watcher.Collection),
+			slog.String("operation", event.OperationType),
+		), slog.String("error", err.Error()))
+	}
+}
+
+// flushLoop periodically saves the latest fully handled resume token.
+func (self *ChangeStreamService) flushLoop() {
+	interval := self.flushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.ctx.Done():
+			return
+		case <-ticker.C:
+			self.flush(self.ctx)
+		}
+	}
+}
+
+// flush saves the committed token if it changed since the last save.
+func (self *ChangeStreamService) flush(ctx context.Context) {
+	token, ok := self.tracker.take()
+	if !ok {
+		return
+	}
+	if err := self.store.Save(ctx, self.name, token); err != nil {
+		slog.Error("save change stream resume token failed", slog.String("name", self.name), slog.String("error", err.Error()))
+	}
+}
+
+// Shutdown stops the stream, waits for the workers to exit and saves the last
+// handled resume token.
+func (self *ChangeStreamService) Shutdown() {
+	self.once.Do(func() {
+		self.cancel()
+		// dispatch only starts workers under workerMutex while the service is not
+		// canceled, so after this barrier no workerGroup.Add can race with Wait.
+		self.workerMutex.Lock()
+		self.workerMutex.Unlock()
+		self.workerGroup.Wait()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		self.flush(ctx)
+	})
+}
+
+// isResumeTokenLost reports whether err means the resume token can no longer be used.
+func isResumeTokenLost(err error) bool {
+	var serverError mongo.ServerError
+	if !errors.As(err, &serverError) {
+		return false
+	}
+	return serverError.HasErrorCode(errorCodeInvalidResumeToken) ||
+		serverError.HasErrorCode(errorCodeChangeStreamFatal) ||
+		serverError.HasErrorCode(errorCodeChangeStreamHistoryLost)
+}
+
+// pendingToken is the resume token of one dispatched event.
+type pendingToken struct {
+	token bson.Raw
+	done  bool
+}
+
+// tokenTracker records dispatched events in stream order and exposes the token
+// of the newest event for which every earlier event has been handled.
+type tokenTracker struct {
+	mutex     sync.Mutex
+	pending   []*pendingToken
+	committed bson.Raw
+	dirty     bool
+}
+
+func (self *tokenTracker) add(token bson.Raw) *pendingToken {
+	entry := &pendingToken{token: token}
+	self.mutex.Lock()
+	self.pending = append(self.pending, entry)
+	self.mutex.Unlock()
+	return entry
+}
+
+func (self *tokenTracker) done(entry *pendingToken) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	entry.done = true
+	index := 0
+	for index < len(self.pending) && self.pending[index].done {
+		self.committed = self.pending[index].token
+		self.dirty = true
+		index++
+	}
+	self.pending = self.pending[index:]
+}
+
+func (self *tokenTracker) take() (bson.Raw, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.dirty {
+		return nil, false
+	}
+	self.dirty = false
+	return self.committed, true
+}
+
+var (
+	defaultChangeStream *ChangeStreamService
+)
+
+// InitChangeStreamService creates the global ChangeStreamService from the
+// mongo.change-stream.* configuration. It must run after InitMongoDB.
+//
+// The service watches mongo.change-stream.collection, or the whole database
+// when it is empty, and stores resume tokens in the
+// mongo.change-stream.token-collection collection.
+func InitChangeStreamService(ctx context.Context) (func(), error) {
+	if mongoDB == nil {
+		return nil, errors.New("mongo database is not initialized")
+	}
+	var target Watchable = mongoDB
+	if collection := config.ViperGet[string]("mongo.change-stream.collection"); collection != "" {
+		target = Collection(collection)
+	}
+	store := NewMongoTokenStore(config.ViperGet[string]("mongo.change-stream.token-collection", "_change_stream_tokens"))
+	defaultChangeStream = NewChangeStreamService(ctx, config.ViperGet[string]("mongo.change-stream.name", "default"), target, store)
+	defaultChangeStream.flushInterval = config.ViperGet[time.Duration]("mongo.change-stream.flush-interval", time.Second)
+	return func() {
+		defaultChangeStream.Shutdown()
+	}, nil
+}
+
+// ChangeStreamInstance returns the default global ChangeStreamService instance.
+func ChangeStreamInstance() *ChangeStreamService {
+	return defaultChangeStream
+}
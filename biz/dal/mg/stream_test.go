@@ -0,0 +1,63 @@
+package mg
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func testToken(t *testing.T, value string) bson.Raw {
+	t.Helper()
+	token, err := bson.Marshal(bson.D{{Key: "_data", Value: value}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenTracker_InOrder(t *testing.T) {
+	tracker := &tokenTracker{}
+	if _, ok := tracker.take(); ok {
+		t.Fatal("expected no token before any event is done")
+	}
+	first := tracker.add(testToken(t, "1"))
+	second := tracker.add(testToken(t, "2"))
+	tracker.done(first)
+	token, ok := tracker.take()
+	if !ok || !bytes.Equal(token, testToken(t, "1")) {
+		t.Errorf("expected token 1, got %v %v", token, ok)
+	}
+	if _, ok := tracker.take(); ok {
+		t.Error("expected the token to be taken only once")
+	}
+	tracker.done(second)
+	token, ok = tracker.take()
+	if !ok || !bytes.Equal(token, testToken(t, "2")) {
+		t.Errorf("expected token 2, got %v %v", token, ok)
+	}
+	if len(tracker.pending) != 0 {
+		t.Errorf("expected no pending entries, got %d", len(tracker.pending))
+	}
+}
+
+func TestTokenTracker_OutOfOrder(t *testing.T) {
+	tracker := &tokenTracker{}
+	first := tracker.add(testToken(t, "1"))
+	second := tracker.add(testToken(t, "2"))
+	third := tracker.add(testToken(t, "3"))
+	// later events finishing first must not move the token past an unfinished one.
+	tracker.done(third)
+	tracker.done(second)
+	if _, ok := tracker.take(); ok {
+		t.Fatal("expected no token while the first event is pending")
+	}
+	tracker.done(first)
+	token, ok := tracker.take()
+	if !ok || !bytes.Equal(token, testToken(t, "3")) {
+		t.Errorf("expected token 3, got %v %v", token, ok)
+	}
+	if len(tracker.pending) != 0 {
+		t.Errorf("expected no pending entries, got %d", len(tracker.pending))
+	}
+}
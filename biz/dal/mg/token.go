@@ -0,0 +1,103 @@
+package mg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ResumeTokenStore persists change stream resume tokens so that a
+// ChangeStreamService can continue from its last handled event after a
+// crash or restart.
+type ResumeTokenStore interface {
+	// Load returns the last saved token for name, or nil if none exists.
+	Load(ctx context.Context, name string) (bson.Raw, error)
+
+	// Save stores token as the latest handled position for name.
+	Save(ctx context.Context, name string, token bson.Raw) error
+
+	// Delete removes the saved token for name, for example when it is no
+	// longer present in the oplog.
+	Delete(ctx context.Context, name string) error
+}
+
+// resumeTokenDocument is the document layout used by MongoTokenStore.
+type resumeTokenDocument struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// MongoTokenStore stores resume tokens in a MongoDB collection, one document per stream name.
+type MongoTokenStore struct {
+	collection string
+}
+
+// NewMongoTokenStore creates a MongoTokenStore backed by the named collection.
+func NewMongoTokenStore(collection string) *MongoTokenStore {
+	return &MongoTokenStore{collection: collection}
+}
+
+func (self *MongoTokenStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	var document resumeTokenDocument
+	err := Collection(self.collection).FindOne(ctx, bson.D{{Key: "_id", Value: name}}).Decode(&document)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return document.Token, nil
+}
+
+func (self *MongoTokenStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	_, err := Collection(self.collection).ReplaceOne(ctx, bson.D{{Key: "_id", Value: name}}, resumeTokenDocument{
+		Name:      name,
+		Token:     token,
+		UpdatedAt: time.Now(),
+	}, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (self *MongoTokenStore) Delete(ctx context.Context, name string) error {
+	_, err := Collection(self.collection).DeleteOne(ctx, bson.D{{Key: "_id", Value: name}})
+	return err
+}
+
+// RedisTokenStore stores resume tokens as plain Redis strings under prefix + name.
+type RedisTokenStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisTokenStore creates a RedisTokenStore using the given client and key prefix.
+func NewRedisTokenStore(client redis.UniversalClient, prefix string) *RedisTokenStore {
+	return &RedisTokenStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (self *RedisTokenStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	value, err := self.client.Get(ctx, self.prefix+name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (self *RedisTokenStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	return self.client.Set(ctx, self.prefix+name, []byte(token), 0).Err()
+}
+
+func (self *RedisTokenStore) Delete(ctx context.Context, name string) error {
+	return self.client.Del(ctx, self.prefix+name).Err()
+}
@@ -0,0 +1,38 @@
+package mg
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// WithTransaction runs fn inside a MongoDB transaction on a new session and
+// commits it when fn returns nil.
+//
+// The whole transaction is retried when the server labels an error as a
+// TransientTransactionError, and the commit is retried on
+// UnknownTransactionCommitResult, for up to 120 seconds. fn may therefore run
+// more than once and must not have side effects outside the database.
+//
+// Every operation inside fn must use the ctx passed to fn, which carries the session.
+//
+// Example:
+//
+//	err := mg.WithTransaction(ctx, func(ctx context.Context) error {
+//		if _, err := orderRepo.InsertOne(ctx, order); err != nil {
+//			return err
+//		}
+//		_, err := stockRepo.UpdateByID(ctx, stock.ID, stock)
+//		return err
+//	})
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error, opts ...options.Lister[options.TransactionOptions]) error {
+	session, err := mongoClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	}, opts...)
+	return err
+}
@@ -10,7 +10,7 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/wnnce/fserv-template/biz/mw/kafka"
 )
 
 const (
@@ -29,17 +29,23 @@ func NewExampleEvent(offset int) ExampleEvent {
 	}
 }
 
-func HandlerExampleEvent(_ context.Context, _ *kgo.Client, _ bool, records ...*kgo.Record) {
-	if len(records) == 0 {
-		return
-	}
-	for _, record := range records {
-		key, value, topic := record.Key, record.Value, record.Topic
-		slog.Info(
-			"handler example event message",
-			slog.String("topic", topic),
-			slog.String("key", string(key)),
-			slog.String("value", string(value)),
-		)
-	}
+func init() {
+	kafka.RegisterTopics(kafka.NewTopic(ExampleTopic).Partitions(3).Retention(7 * 24 * time.Hour))
+}
+
+// NewExampleConsumer returns the consumer of ExampleTopic.
+func NewExampleConsumer() kafka.Consumer {
+	return kafka.NewTypedConsumer(ExampleTopic, HandlerExampleEvent)
+}
+
+func HandlerExampleEvent(ctx context.Context, event ExampleEvent, meta kafka.RecordMeta) error {
+	slog.InfoContext(
+		ctx,
+		"handler example event message",
+		slog.String("topic", meta.Topic),
+		slog.String("key", string(meta.Key)),
+		slog.Int("offset", event.Offset),
+		slog.Int64("timestamp", event.Timestamp),
+	)
+	return nil
 }
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"golang.org/x/sync/singleflight"
+)
+
+// Every entry is stored in a small envelope: one kind byte, the loader
+// duration and the expiry in milliseconds, followed by the serialized value.
+// The duration and expiry drive probabilistic early refresh.
+const (
+	kindValue    byte = 1 // kindValue marks an entry holding a serialized value.
+	kindNotFound byte = 2 // kindNotFound marks a negative cache entry.
+	headerLength      = 17
+)
+
+// loadTimeout bounds a shared load, which runs detached from the context of
+// the caller that started it.
+const loadTimeout = 10 * time.Second
+
+// ErrNotFound is returned by a loader to signal that the value does not
+// exist. The result is cached as a negative entry, and GetOrLoad returns
+// ErrNotFound to the caller until the negative entry expires.
+var ErrNotFound = errors.New("cache: value not found")
+
+// Loader loads the value from the source of truth on a cache miss.
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// settings holds the behavior of a single cache call.
+type settings struct {
+	client      goredis.UniversalClient
+	serializer  Serializer
+	jitter      float64
+	negativeTTL time.Duration
+	beta        float64
+}
+
+// Option customizes a cache call.
+type Option func(opts *settings)
+
+// WithClient uses client instead of the default Redis client.
+func WithClient(client goredis.UniversalClient) Option {
+	return func(opts *settings) {
+		opts.client = client
+	}
+}
+
+// WithSerializer encodes values with serializer instead of JSONSerializer.
+func WithSerializer(serializer Serializer) Option {
+	return func(opts *settings) {
+		opts.serializer = serializer
+	}
+}
+
+// WithJitter extends every TTL by a random duration of up to fraction*ttl so
+// that keys written together do not expire together. The default is 0.1.
+func WithJitter(fraction float64) Option {
+	return func(opts *settings) {
+		opts.jitter = fraction
+	}
+}
+
+// WithNegativeTTL sets how long an ErrNotFound result is cached. Zero
+// disables negative caching. The default is 30 seconds.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(opts *settings) {
+		opts.negativeTTL = ttl
+	}
+}
+
+// WithEarlyRefresh sets the beta factor of probabilistic early refresh.
+// Larger values refresh earlier, and zero disables early refresh. The
+// default is 1.0.
+func WithEarlyRefresh(beta float64) Option {
+	return func(opts *settings) {
+		opts.beta = beta
+	}
+}
+
+func newSettings(opts []Option) *settings {
+	result := &settings{
+		serializer:  JSONSerializer,
+		jitter:      0.1,
+		negativeTTL: 30 * time.Second,
+		beta:        1.0,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	if result.client == nil {
+		result.client = redis.RedisClient()
+	}
+	return result
+}
+
+var group singleflight.Group
+
+// GetOrLoad returns the cached value of key, calling loader on a miss and
+// caching its result for ttl.
+//
+// Concurrent misses of the same key within this process share a single loader
+// call. Close to expiry, a cache hit may trigger a background refresh with a
+// probability that grows as the entry ages (XFetch), so hot keys are renewed
+// before they expire instead of stampeding the source. Redis errors are
+// logged and treated as misses.
+//
+// Example:
+//
+//	product, err := cache.GetOrLoad(ctx, "product:"+id, 10*time.Minute, func(ctx context.Context) (*Product, error) {
+//		product, err := productRepo.FindByID(ctx, id)
+//		if errors.Is(err, mongo.ErrNoDocuments) {
+//			return nil, cache.ErrNotFound
+//		}
+//		return product, err
+//	})
+func GetOrLoad[T any](ctx context.Context, key string, ttl time.Duration, loader Loader[T], opts ...Option) (T, error) {
+	value, _, _, err := getOrLoad(ctx, key, ttl, loader, newSettings(opts))
+	return value, err
+}
+
+// getOrLoad implements GetOrLoad. It reports whether the result came from
+// Redis rather than from a load, shared or not, and for a result from Redis
+// the time left until the entry expires there, 0 when it does not expire.
+func getOrLoad[T any](ctx context.Context, key string, ttl time.Duration, loader Loader[T], settings *settings) (T, time.Duration, bool, error) {
+	var zero T
+	data, err := settings.client.Get(ctx, key).Bytes()
+	switch {
+	case err == nil:
+		value, found, refresh, remaining, decodeErr := decodeEntry[T](data, settings)
+		if decodeErr == nil {
+			if !found {
+				return zero, remaining, true, ErrNotFound
+			}
+			if refresh {
+				go func() {
+					refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+					defer cancel()
+					if _, err := load(refreshCtx, key, ttl, loader, settings); err != nil && !errors.Is(err, ErrNotFound) {
+						slog.WarnContext(refreshCtx, "cache early refresh failed", slog.String("key", key), slog.String("error", err.Error()))
+					}
+				}()
+			}
+			return value, remaining, true, nil
+		}
+		slog.WarnContext(ctx, "cache entry decode failed, reloading", slog.String("key", key), slog.String("error", decodeErr.Error()))
+	case errors.Is(err, goredis.Nil):
+	default:
+		slog.WarnContext(ctx, "cache read failed, loading from source", slog.String("key", key), slog.String("error", err.Error()))
+	}
+	value, err := load(ctx, key, ttl, loader, settings)
+	return value, 0, false, err
+}
+
+// Set stores value under key for ttl, applying the same encoding and jitter as GetOrLoad.
+func Set[T any](ctx context.Context, key string, value T, ttl time.Duration, opts ...Option) error {
+	settings := newSettings(opts)
+	payload, err := settings.serializer.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return store(ctx, key, kindValue, payload, 0, ttl, settings)
+}
+
+// Delete removes the given keys, including negative entries.
+func Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return newSettings(nil).client.Del(ctx, keys...).Err()
+}
+
+// load calls loader once per key across concurrent callers and caches the result.
+// The loader and the write run detached from the caller that started the load,
+// bounded by loadTimeout, so one caller giving up fails neither the others nor
+// the write; each caller still stops waiting when its own ctx is done.
+func load[T any](ctx context.Context, key string, ttl time.Duration, loader Loader[T], settings *settings) (T, error) {
+	var zero T
+	results := group.DoChan(key, func() (_ any, err error) {
+		// DoChan re-panics on its own goroutine, where no caller could recover.
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("cache: loader of key %s panicked: %v", key, r)
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+		begin := time.Now()
+		value, err := loader(ctx)
+		delta := time.Since(begin)
+		if errors.Is(err, ErrNotFound) {
+			if settings.negativeTTL > 0 {
+				if err = store(ctx, key, kindNotFound, nil, delta, settings.negativeTTL, settings); err != nil {
+					slog.WarnContext(ctx, "cache negative write failed", slog.String("key", key), slog.String("error", err.Error()))
+				}
+			}
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		payload, err := settings.serializer.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if err = store(ctx, key, kindValue, payload, delta, ttl, settings); err != nil {
+			slog.WarnContext(ctx, "cache write failed", slog.String("key", key), slog.String("error", err.Error()))
+		}
+		return value, nil
+	})
+	var result singleflight.Result
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case result = <-results:
+	}
+	if result.Err != nil {
+		return zero, result.Err
+	}
+	value, ok := result.Val.(T)
+	if !ok {
+		return zero, fmt.Errorf("cache: key %s was loaded as %T", key, result.Val)
+	}
+	return value, nil
+}
+
+// store writes an envelope with the given kind and payload, extending ttl by jitter.
+func store(ctx context.Context, key string, kind byte, payload []byte, delta, ttl time.Duration, settings *settings) error {
+	if settings.jitter > 0 && ttl > 0 {
+		ttl += time.Duration(rand.Int64N(int64(float64(ttl)*settings.jitter) + 1))
+	}
+	data := make([]byte, headerLength, headerLength+len(payload))
+	data[0] = kind
+	binary.BigEndian.PutUint64(data[1:9], uint64(delta.Milliseconds()))
+	// an entry without ttl does not expire, which is stored as expireAt 0.
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data[9:17], uint64(time.Now().Add(ttl).UnixMilli()))
+	}
+	data = append(data, payload...)
+	return settings.client.Set(ctx, key, data, ttl).Err()
+}
+
+// decodeEntry decodes an envelope and reports whether it holds a value,
+// whether it should be refreshed early and the time left until it expires,
+// 0 when it does not expire.
+func decodeEntry[T any](data []byte, settings *settings) (T, bool, bool, time.Duration, error) {
+	var value T
+	if len(data) < headerLength {
+		return value, false, false, 0, errors.New("cache entry is too short")
+	}
+	delta := int64(binary.BigEndian.Uint64(data[1:9]))
+	expireAt := int64(binary.BigEndian.Uint64(data[9:17]))
+	var remaining time.Duration
+	if expireAt > 0 {
+		// the entry is still in Redis, so it has at least a moment left.
+		remaining = max(time.Until(time.UnixMilli(expireAt)), time.Millisecond)
+	}
+	switch data[0] {
+	case kindNotFound:
+		return value, false, false, remaining, nil
+	case kindValue:
+	default:
+		return value, false, false, 0, fmt.Errorf("unknown cache entry kind %d", data[0])
+	}
+	if err := settings.serializer.Unmarshal(data[headerLength:], &value); err != nil {
+		return value, false, false, 0, err
+	}
+	return value, true, shouldRefresh(delta, expireAt, settings.beta), remaining, nil
+}
+
+// shouldRefresh implements the XFetch early expiration check: an entry is
+// refreshed when now - delta*beta*ln(rand) passes its expiry.
+func shouldRefresh(delta, expireAt int64, beta float64) bool {
+	if beta <= 0 || delta <= 0 || expireAt <= 0 {
+		return false
+	}
+	gap := -float64(delta) * beta * math.Log(rand.Float64())
+	return time.Now().UnixMilli()+int64(gap) >= expireAt
+}
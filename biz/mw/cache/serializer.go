@@ -0,0 +1,15 @@
+package cache
+
+import "github.com/wnnce/fserv-template/pkg/codec"
+
+// Serializer encodes cached values to bytes and back.
+type Serializer = codec.Codec
+
+var (
+	// JSONSerializer encodes values with sonic, matching the redis helpers.
+	JSONSerializer = codec.JSON
+
+	// MsgpackSerializer encodes values with msgpack, which is more compact for
+	// large or numeric-heavy values.
+	MsgpackSerializer = codec.Msgpack
+)
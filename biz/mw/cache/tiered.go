@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/config"
+	"github.com/wnnce/fserv-template/pkg/tool"
+)
+
+// invalidateChannel is the Redis pub/sub channel used to broadcast evictions.
+const invalidateChannel = "cache:invalidate"
+
+var (
+	instanceID     = uuid.NewString()
+	namespaceMutex sync.Mutex
+	namespaces     = make(map[string]evicter)
+)
+
+// evicter is implemented by every Namespace so that invalidation messages can
+// be routed without knowing the value type.
+type evicter interface {
+	evict(keys ...string)
+	clearLocal()
+	Stats() Stats
+}
+
+// invalidation is the message broadcast when keys of a namespace change.
+type invalidation struct {
+	Origin    string   `json:"origin"`
+	Namespace string   `json:"namespace"`
+	Keys      []string `json:"keys"`
+}
+
+// Stats is a snapshot of the hit/miss counters of a Namespace.
+type Stats struct {
+	Namespace  string `json:"namespace"`
+	Size       int    `json:"size"`
+	LocalHits  uint64 `json:"localHits"`
+	RemoteHits uint64 `json:"remoteHits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+}
+
+// localEntry is a value held in the in-process tier; notFound marks a
+// negative entry.
+type localEntry[T any] struct {
+	value    T
+	notFound bool
+}
+
+// Namespace is a two-tier cache: a size-limited in-process LRU in front of
+// Redis. Redis keys are prefixed with "<name>:". Writes and deletes through a
+// Namespace are broadcast over Redis pub/sub so every instance evicts the key
+// from its local tier; the local TTL bounds staleness if a message is missed.
+//
+// Example:
+//
+//	var dictCache = cache.NewNamespace[*Dict]("dict", 1024, 30*time.Second)
+//
+//	dict, err := dictCache.GetOrLoad(ctx, code, 10*time.Minute, func(ctx context.Context) (*Dict, error) {
+//		return dictRepo.FindOne(ctx, mg.NewFilter().Eq("code", code))
+//	})
+type Namespace[T any] struct {
+	name       string
+	localTTL   time.Duration
+	local      *tool.LRU[string, localEntry[T]]
+	opts       []Option
+	localHits  atomic.Uint64
+	remoteHits atomic.Uint64
+	misses     atomic.Uint64
+	evictions  atomic.Uint64
+}
+
+// NewNamespace creates and registers a Namespace holding at most capacity
+// local entries, each kept locally for at most localTTL, or as long as in Redis
+// when localTTL is 0. opts apply to the Redis tier as in GetOrLoad. Creating
+// two namespaces with the same name panics.
+func NewNamespace[T any](name string, capacity int, localTTL time.Duration, opts ...Option) *Namespace[T] {
+	namespace := &Namespace[T]{
+		name:     name,
+		localTTL: localTTL,
+		opts:     opts,
+	}
+	namespace.local = tool.NewLRU[string, localEntry[T]](capacity, func(_ string, _ localEntry[T]) {
+		namespace.evictions.Add(1)
+	})
+	namespaceMutex.Lock()
+	defer namespaceMutex.Unlock()
+	if _, ok := namespaces[name]; ok {
+		panic("duplicate cache namespace " + name)
+	}
+	namespaces[name] = namespace
+	return namespace
+}
+
+// GetOrLoad returns the value of key from the local tier, then from Redis, and
+// finally from loader, filling both tiers on the way back.
+func (self *Namespace[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader Loader[T]) (T, error) {
+	var zero T
+	if entry, ok := self.local.Get(key); ok {
+		self.localHits.Add(1)
+		if entry.notFound {
+			return zero, ErrNotFound
+		}
+		return entry.value, nil
+	}
+	settings := newSettings(self.opts)
+	// callers sharing a singleflight load count as misses as well, since
+	// neither tier held the key for them.
+	value, remaining, remote, err := getOrLoad(ctx, self.redisKey(key), ttl, loader, settings)
+	negativeTTL := settings.negativeTTL
+	if remote {
+		self.remoteHits.Add(1)
+		// an entry read from Redis lives locally no longer than it has left there.
+		ttl, negativeTTL = remaining, remaining
+	} else {
+		self.misses.Add(1)
+	}
+	if errors.Is(err, ErrNotFound) {
+		if remote || negativeTTL > 0 {
+			self.local.Set(key, localEntry[T]{notFound: true}, self.localExpiry(negativeTTL))
+		}
+		return zero, err
+	}
+	if err != nil {
+		return zero, err
+	}
+	self.local.Set(key, localEntry[T]{value: value}, self.localExpiry(ttl))
+	return value, nil
+}
+
+// Set writes value to both tiers and tells other instances to evict key.
+func (self *Namespace[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	if err := Set(ctx, self.redisKey(key), value, ttl, self.opts...); err != nil {
+		return err
+	}
+	self.local.Set(key, localEntry[T]{value: value}, self.localExpiry(ttl))
+	return self.publish(ctx, key)
+}
+
+// Delete removes keys from both tiers on every instance.
+func (self *Namespace[T]) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, self.redisKey(key))
+	}
+	if err := newSettings(self.opts).client.Del(ctx, redisKeys...).Err(); err != nil {
+		return err
+	}
+	self.evict(keys...)
+	return self.publish(ctx, keys...)
+}
+
+// Stats returns a snapshot of the namespace counters.
+func (self *Namespace[T]) Stats() Stats {
+	return Stats{
+		Namespace:  self.name,
+		Size:       self.local.Len(),
+		LocalHits:  self.localHits.Load(),
+		RemoteHits: self.remoteHits.Load(),
+		Misses:     self.misses.Load(),
+		Evictions:  self.evictions.Load(),
+	}
+}
+
+// localExpiry returns how long an entry stored in Redis for ttl is kept
+// locally: the shorter of localTTL and ttl, where 0 means no limit. A localTTL
+// of 0 thus keeps the entry locally as long as it lives in Redis.
+func (self *Namespace[T]) localExpiry(ttl time.Duration) time.Duration {
+	if self.localTTL <= 0 {
+		return ttl
+	}
+	if ttl <= 0 {
+		return self.localTTL
+	}
+	return min(self.localTTL, ttl)
+}
+
+func (self *Namespace[T]) evict(keys ...string) {
+	for _, key := range keys {
+		self.local.Delete(key)
+	}
+}
+
+func (self *Namespace[T]) clearLocal() {
+	self.local.Clear()
+}
+
+func (self *Namespace[T]) redisKey(key string) string {
+	return self.name + ":" + key
+}
+
+func (self *Namespace[T]) publish(ctx context.Context, keys ...string) error {
+	message, err := sonic.Marshal(invalidation{Origin: instanceID, Namespace: self.name, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return newSettings(self.opts).client.Publish(ctx, invalidateChannel, message).Err()
+}
+
+// AllStats returns the stats of every registered namespace, sorted by name.
+func AllStats() []Stats {
+	namespaceMutex.Lock()
+	result := make([]Stats, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		result = append(result, namespace.Stats())
+	}
+	namespaceMutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result
+}
+
+// InitInvalidation subscribes to the invalidation channel so that writes on
+// other instances evict local entries here. It must run after InitRedis.
+// Whenever the subscription is re-established, every local tier is cleared
+// because messages may have been missed while disconnected.
+func InitInvalidation(ctx context.Context) (func(), error) {
+	if !config.ViperGet[bool]("cache.invalidation", true) {
+		return func() {}, nil
+	}
+	pubsub := redis.RedisClient().Subscribe(ctx, invalidateChannel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	go func() {
+		for message := range pubsub.ChannelWithSubscriptions() {
+			switch v := message.(type) {
+			case *goredis.Subscription:
+				// the first subscription was confirmed by Receive above, so any
+				// later one is a resubscription after a reconnect.
+				slog.Warn("cache invalidation resubscribed, clearing local caches")
+				clearLocalCaches()
+			case *goredis.Message:
+				handleInvalidation(v.Payload)
+			}
+		}
+		slog.Info("cache invalidation subscriber exit")
+	}()
+	return func() {
+		_ = pubsub.Close()
+	}, nil
+}
+
+func clearLocalCaches() {
+	namespaceMutex.Lock()
+	defer namespaceMutex.Unlock()
+	for _, namespace := range namespaces {
+		namespace.clearLocal()
+	}
+}
+
+func handleInvalidation(payload string) {
+	var message invalidation
+	if err := sonic.UnmarshalString(payload, &message); err != nil {
+		slog.Warn("cache invalidation message decode failed", slog.String("error", err.Error()))
+		return
+	}
+	if message.Origin == instanceID {
+		return
+	}
+	namespaceMutex.Lock()
+	namespace, ok := namespaces[message.Namespace]
+	namespaceMutex.Unlock()
+	if ok {
+		namespace.evict(message.Keys...)
+	}
+}
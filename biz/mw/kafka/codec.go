@@ -0,0 +1,21 @@
+package kafka
+
+import "github.com/wnnce/fserv-template/pkg/codec"
+
+// Codec encodes typed record values to bytes and back.
+type Codec = codec.Codec
+
+var (
+	// JSONCodec encodes values with sonic, matching ProducerWithSync and ProducerWithAsync.
+	JSONCodec = codec.JSON
+
+	// ProtobufCodec encodes proto.Message values. Typed consumers using it
+	// must be declared with the message pointer type, e.g. *pb.OrderCreated.
+	ProtobufCodec = codec.Protobuf
+
+	// MsgpackCodec encodes values with msgpack.
+	MsgpackCodec = codec.Msgpack
+
+	// RawCodec passes []byte and string values through unchanged.
+	RawCodec = codec.Raw
+)
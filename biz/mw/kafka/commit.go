@@ -0,0 +1,243 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// commit.go
+//
+// Description: managed commit mode, used when kafka.commit is false. Every
+// fetched record is tracked from dispatch until its handler finishes, and the
+// commit point of a partition only advances past a record once it and every
+// earlier record of the partition are done. Records whose handling could not
+// complete hold the commit point back, so they are delivered again after a
+// restart or rebalance: at-least-once, with duplicates limited to the records
+// handled after the first unfinished one.
+
+// trackedRecord is a dispatched record and whether its handling completed.
+type trackedRecord struct {
+	record *kgo.Record
+	done   bool
+}
+
+// partitionOffsets tracks the dispatched records of one partition in offset order.
+type partitionOffsets struct {
+	queue   []*trackedRecord
+	index   map[*kgo.Record]*trackedRecord
+	commit  kgo.EpochOffset
+	pending bool
+}
+
+// offsetTracker holds the commit state of every assigned partition.
+type offsetTracker struct {
+	mutex      sync.Mutex
+	partitions map[string]map[int32]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[string]map[int32]*partitionOffsets)}
+}
+
+// track registers a record that is about to be dispatched.
+func (self *offsetTracker) track(record *kgo.Record) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	partitions, ok := self.partitions[record.Topic]
+	if !ok {
+		partitions = make(map[int32]*partitionOffsets)
+		self.partitions[record.Topic] = partitions
+	}
+	offsets, ok := partitions[record.Partition]
+	if !ok {
+		offsets = &partitionOffsets{index: make(map[*kgo.Record]*trackedRecord)}
+		partitions[record.Partition] = offsets
+	}
+	tracked := &trackedRecord{record: record}
+	offsets.queue = append(offsets.queue, tracked)
+	offsets.index[record] = tracked
+}
+
+// untrack forgets a record that could not be dispatched, together with the
+// records tracked after it in its partition, so the commit point neither waits
+// for them nor advances past them.
+func (self *offsetTracker) untrack(record *kgo.Record) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	offsets, ok := self.partitions[record.Topic][record.Partition]
+	if !ok {
+		return
+	}
+	for index, item := range offsets.queue {
+		if item.record != record {
+			continue
+		}
+		for _, later := range offsets.queue[index:] {
+			delete(offsets.index, later.record)
+		}
+		offsets.queue = offsets.queue[:index]
+		return
+	}
+}
+
+// markDone marks records as handled and advances the commit point of their
+// partitions over the completed prefix. Records of partitions that have been
+// revoked since they were tracked are ignored.
+func (self *offsetTracker) markDone(records ...*kgo.Record) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, record := range records {
+		offsets, ok := self.partitions[record.Topic][record.Partition]
+		if !ok {
+			continue
+		}
+		tracked, ok := offsets.index[record]
+		if !ok {
+			continue
+		}
+		tracked.done = true
+		completed := 0
+		for _, item := range offsets.queue {
+			if !item.done {
+				break
+			}
+			offsets.commit = kgo.EpochOffset{Epoch: item.record.LeaderEpoch, Offset: item.record.Offset + 1}
+			offsets.pending = true
+			delete(offsets.index, item.record)
+			completed++
+		}
+		if completed > 0 {
+			offsets.queue = append(offsets.queue[:0], offsets.queue[completed:]...)
+		}
+	}
+}
+
+// uncommitted returns the commit points that advanced since the last commit,
+// limited to topics when it is not nil.
+func (self *offsetTracker) uncommitted(topics map[string][]int32) map[string]map[int32]kgo.EpochOffset {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	result := make(map[string]map[int32]kgo.EpochOffset)
+	for topic, partitions := range self.partitions {
+		for partition, offsets := range partitions {
+			if !offsets.pending || (topics != nil && !slices.Contains(topics[topic], partition)) {
+				continue
+			}
+			if _, ok := result[topic]; !ok {
+				result[topic] = make(map[int32]kgo.EpochOffset)
+			}
+			result[topic][partition] = offsets.commit
+		}
+	}
+	return result
+}
+
+// committed clears the pending flag of commit points that were committed and
+// have not advanced since.
+func (self *offsetTracker) committed(offsets map[string]map[int32]kgo.EpochOffset) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			if current, ok := self.partitions[topic][partition]; ok && current.commit == offset {
+				current.pending = false
+			}
+		}
+	}
+}
+
+// remove forgets the given partitions, e.g. after they were revoked.
+func (self *offsetTracker) remove(topics map[string][]int32) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for topic, partitions := range topics {
+		for _, partition := range partitions {
+			delete(self.partitions[topic], partition)
+		}
+		if len(self.partitions[topic]) == 0 {
+			delete(self.partitions, topic)
+		}
+	}
+}
+
+// managedCommit reports whether the service commits offsets itself.
+func (self *Service) managedCommit() bool {
+	return !self.autoCommit && self.tracker != nil
+}
+
+// commitTracked synchronously commits the advanced commit points, limited to
+// topics when it is not nil.
+func (self *Service) commitTracked(ctx context.Context, topics map[string][]int32) {
+	if !self.managedCommit() {
+		return
+	}
+	offsets := self.tracker.uncommitted(topics)
+	if len(offsets) == 0 {
+		return
+	}
+	self.client.CommitOffsetsSync(ctx, offsets, func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest,
+		resp *kmsg.OffsetCommitResponse, err error) {
+		if err != nil {
+			slog.Error("kafka commit offsets failed", slog.String("name", self.name), slog.String("error", err.Error()))
+			return
+		}
+		for _, topic := range resp.Topics {
+			for _, partition := range topic.Partitions {
+				if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+					slog.Error("kafka commit partition offset failed", slog.Group("data",
+						slog.String("topic", topic.Topic),
+						slog.Int("partition", int(partition.Partition)),
+					), slog.String("error", err.Error()))
+					delete(offsets[topic.Topic], partition.Partition)
+				}
+			}
+		}
+		self.tracker.committed(offsets)
+	})
+}
+
+// commitLoop commits the tracked offsets every interval until the service stops.
+func (self *Service) commitLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.ctx.Done():
+			return
+		case <-ticker.C:
+			self.commitTracked(self.ctx, nil)
+		}
+	}
+}
+
+// OnPartitionsRevoked commits what was handled on the revoked partitions
+// before another member takes them over, then forgets them, stops their workers
+// and makes shared workers skip their buffered records.
+// Its signature matches kgo.OnPartitionsRevoked.
+func (self *Service) OnPartitionsRevoked(ctx context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	if self.managedCommit() {
+		self.commitTracked(ctx, revoked)
+		self.tracker.remove(revoked)
+	}
+	self.revokeGeneration(revoked)
+	self.dropDelayed(revoked)
+	self.removePartitionWorkers(revoked)
+}
+
+// OnPartitionsLost forgets partitions that were lost without a chance to commit,
+// stops their workers and makes shared workers skip their buffered records. Its signature matches kgo.OnPartitionsLost.
+func (self *Service) OnPartitionsLost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	if self.managedCommit() {
+		self.tracker.remove(lost)
+	}
+	self.revokeGeneration(lost)
+	self.dropDelayed(lost)
+	self.removePartitionWorkers(lost)
+}
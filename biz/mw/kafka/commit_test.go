@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func testRecords(topic string, partition int32, offsets ...int64) []*kgo.Record {
+	records := make([]*kgo.Record, 0, len(offsets))
+	for _, offset := range offsets {
+		records = append(records, &kgo.Record{Topic: topic, Partition: partition, Offset: offset, LeaderEpoch: 1})
+	}
+	return records
+}
+
+func TestOffsetTracker_MarkDone(t *testing.T) {
+	tracker := newOffsetTracker()
+	records := testRecords("a", 0, 10, 11, 12)
+	for _, record := range records {
+		tracker.track(record)
+	}
+	if offsets := tracker.uncommitted(nil); len(offsets) != 0 {
+		t.Fatalf("expected nothing to commit, got %v", offsets)
+	}
+	// a later record finishing first must not move the commit point.
+	tracker.markDone(records[1])
+	if offsets := tracker.uncommitted(nil); len(offsets) != 0 {
+		t.Fatalf("expected nothing to commit, got %v", offsets)
+	}
+	tracker.markDone(records[0])
+	expected := map[string]map[int32]kgo.EpochOffset{"a": {0: {Epoch: 1, Offset: 12}}}
+	if offsets := tracker.uncommitted(nil); !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("expected %v, got %v", expected, offsets)
+	}
+	tracker.markDone(records[2])
+	expected = map[string]map[int32]kgo.EpochOffset{"a": {0: {Epoch: 1, Offset: 13}}}
+	if offsets := tracker.uncommitted(nil); !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("expected %v, got %v", expected, offsets)
+	}
+}
+
+func TestOffsetTracker_Committed(t *testing.T) {
+	tracker := newOffsetTracker()
+	records := testRecords("a", 0, 1, 2)
+	for _, record := range records {
+		tracker.track(record)
+	}
+	tracker.markDone(records[0])
+	offsets := tracker.uncommitted(nil)
+	// the commit point advancing during the commit keeps it pending.
+	tracker.markDone(records[1])
+	tracker.committed(offsets)
+	expected := map[string]map[int32]kgo.EpochOffset{"a": {0: {Epoch: 1, Offset: 3}}}
+	if result := tracker.uncommitted(nil); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	tracker.committed(expected)
+	if result := tracker.uncommitted(nil); len(result) != 0 {
+		t.Errorf("expected nothing to commit, got %v", result)
+	}
+}
+
+func TestOffsetTracker_Untrack(t *testing.T) {
+	tracker := newOffsetTracker()
+	records := testRecords("a", 0, 1, 2, 3)
+	for _, record := range records {
+		tracker.track(record)
+	}
+	tracker.markDone(records[0])
+	tracker.untrack(records[1])
+	// records after the untracked one are dropped and must not advance the commit point.
+	tracker.markDone(records[2])
+	expected := map[string]map[int32]kgo.EpochOffset{"a": {0: {Epoch: 1, Offset: 2}}}
+	if result := tracker.uncommitted(nil); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+	offsets := tracker.partitions["a"][0]
+	if len(offsets.queue) != 0 || len(offsets.index) != 0 {
+		t.Errorf("expected an empty queue and index, got %d and %d", len(offsets.queue), len(offsets.index))
+	}
+}
+
+func TestOffsetTracker_Remove(t *testing.T) {
+	tracker := newOffsetTracker()
+	first := testRecords("a", 0, 1)[0]
+	second := testRecords("a", 1, 1)[0]
+	tracker.track(first)
+	tracker.track(second)
+	tracker.remove(map[string][]int32{"a": {0}})
+	// records of removed partitions are ignored.
+	tracker.markDone(first, second)
+	expected := map[string]map[int32]kgo.EpochOffset{"a": {1: {Epoch: 1, Offset: 2}}}
+	if result := tracker.uncommitted(nil); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+	if result := tracker.uncommitted(map[string][]int32{"a": {0}}); len(result) != 0 {
+		t.Errorf("expected nothing to commit for partition 0, got %v", result)
+	}
+}
@@ -2,17 +2,37 @@ package kafka
 
 import (
 	"context"
+	"slices"
 	"strings"
 
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-// Consumer represents a Kafka topic consumer with batch settings and a message handler.
+// ConcurrencyMode selects how the records of a topic are spread over worker goroutines.
+type ConcurrencyMode int
+
+const (
+	// TopicConcurrency handles every record of the topic on a single worker.
+	TopicConcurrency ConcurrencyMode = iota
+	// PartitionConcurrency runs one worker per assigned partition, preserving partition order.
+	PartitionConcurrency
+	// KeyConcurrency runs a fixed number of workers and routes records by a hash of their key,
+	// preserving the order of records with the same key. Records without a key are routed by
+	// partition, preserving their partition order.
+	KeyConcurrency
+)
+
+// Consumer represents a Kafka topic consumer with batch settings, a message handler,
+// the retry policy applied when the handler fails and the concurrency of its workers.
+// Batches are collected per worker.
 type Consumer struct {
 	Topic         string
 	Batch         bool
 	BatchMaxCount int
 	Handler       MessageHandler
+	Retry         RetryPolicy
+	Concurrency   ConcurrencyMode
+	Workers       int
 }
 
 func NewConsumer(topic string, batch bool, batchMaxCount int, handler MessageHandler) Consumer {
@@ -24,8 +44,32 @@ func NewConsumer(topic string, batch bool, batchMaxCount int, handler MessageHan
 	}
 }
 
+// WithRetry returns a copy of the consumer that handles failures with policy.
+func (self Consumer) WithRetry(policy RetryPolicy) Consumer {
+	self.Retry = policy
+	return self
+}
+
+// WithPartitionWorkers returns a copy of the consumer that handles each partition on its own worker.
+func (self Consumer) WithPartitionWorkers() Consumer {
+	self.Concurrency = PartitionConcurrency
+	return self
+}
+
+// WithKeyWorkers returns a copy of the consumer that handles records on workers workers,
+// routed by record key.
+func (self Consumer) WithKeyWorkers(workers int) Consumer {
+	self.Concurrency = KeyConcurrency
+	self.Workers = workers
+	return self
+}
+
 // MessageHandler defines the function signature for processing consumed Kafka records.
-type MessageHandler func(ctx context.Context, client *kgo.Client, autoCommit bool, records ...*kgo.Record)
+// A nil error marks every record as handled. Returning RecordErrors marks only the
+// listed records as failed, and any other error marks every record as failed; failed
+// records are handled according to the consumer's RetryPolicy. When autoCommit is false
+// the Service commits the offsets of handled records itself, so handlers must not commit.
+type MessageHandler func(ctx context.Context, client *kgo.Client, autoCommit bool, records ...*kgo.Record) error
 
 // RegisterConsumers registers one or more consumers to the Service and adds their topics to the client.
 func (self *Service) RegisterConsumers(consumers ...Consumer) {
@@ -40,15 +84,25 @@ func (self *Service) RegisterConsumers(consumers ...Consumer) {
 		if consumer.Batch && consumer.BatchMaxCount <= 1 {
 			consumer.Batch = false
 		}
+		if consumer.Concurrency == KeyConcurrency && consumer.Workers <= 1 {
+			consumer.Concurrency = TopicConcurrency
+		}
 		if _, ok := self.consumers[consumer.Topic]; !ok {
+			consumer.Handler = chainHandler(consumer.Handler, self.middlewares)
 			self.consumers[consumer.Topic] = &consumer
 			self.client.AddConsumeTopics(consumer.Topic)
+			// retry topics are consumed by the same consumer.
+			for _, topic := range consumer.Retry.topics() {
+				self.consumers[topic] = &consumer
+				self.client.AddConsumeTopics(topic)
+			}
 		}
 	}
 	self.mutex.Unlock()
 }
 
-// RemoveConsumers removes consumers for the specified topics and pauses fetching for those topics.
+// RemoveConsumers removes consumers for the specified topics, together with their
+// retry topics, and pauses fetching for those topics.
 func (self *Service) RemoveConsumers(topics ...string) {
 	if self.ctx.Err() != nil {
 		return
@@ -57,6 +111,11 @@ func (self *Service) RemoveConsumers(topics ...string) {
 		return
 	}
 	self.mutex.Lock()
+	for _, topic := range topics {
+		if consumer, ok := self.consumers[topic]; ok {
+			topics = append(topics, consumer.Retry.topics()...)
+		}
+	}
 	for _, topic := range topics {
 		if _, ok := self.consumers[topic]; ok {
 			delete(self.consumers, topic)
@@ -65,12 +124,7 @@ func (self *Service) RemoveConsumers(topics ...string) {
 	}
 	self.mutex.Unlock()
 
-	self.workerMutex.Lock()
-	for _, topic := range topics {
-		if worker, ok := self.workerMap[topic]; ok {
-			worker.cancel()
-			delete(self.workerMap, topic)
-		}
-	}
-	self.workerMutex.Unlock()
+	self.removeWorkers(func(work *workerCtx) bool {
+		return slices.Contains(topics, work.topic)
+	})
 }
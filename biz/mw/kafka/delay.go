@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// delay.go
+//
+// Description: delayed consumption of retry topics. A record republished to a
+// RetryTopic carries the time it becomes due. When ReadLoop meets a record that
+// is not due yet, its partition is paused and the record, together with the
+// records fetched after it from the same partition, is held until a timer
+// releases it. Other topics and partitions keep being fetched and dispatched
+// meanwhile.
+
+// delayedPartition holds the records of a paused partition, in offset order.
+type delayedPartition struct {
+	records []*kgo.Record
+	timer   *time.Timer
+}
+
+// delay holds record when it is not due yet or when earlier records of its
+// partition are held. It reports whether the record was held.
+func (self *Service) delay(record *kgo.Record) bool {
+	self.delayMutex.Lock()
+	defer self.delayMutex.Unlock()
+	if delayed, ok := self.delayed[record.Topic][record.Partition]; ok {
+		delayed.records = append(delayed.records, record)
+		return true
+	}
+	wait, ok := retryWait(record)
+	if !ok || self.delayClosed {
+		return false
+	}
+	if self.delayed == nil {
+		self.delayed = make(map[string]map[int32]*delayedPartition)
+	}
+	if self.delayed[record.Topic] == nil {
+		self.delayed[record.Topic] = make(map[int32]*delayedPartition)
+	}
+	delayed := &delayedPartition{records: []*kgo.Record{record}}
+	delayed.timer = time.AfterFunc(wait, func() {
+		self.release(record.Topic, record.Partition, delayed)
+	})
+	self.delayed[record.Topic][record.Partition] = delayed
+	self.client.PauseFetchPartitions(map[string][]int32{record.Topic: {record.Partition}})
+	return true
+}
+
+// release dispatches the held records of a partition that are due, and resumes
+// fetching the partition once none is held anymore.
+func (self *Service) release(topic string, partition int32, delayed *delayedPartition) {
+	self.delayMutex.Lock()
+	if self.delayClosed || self.delayed[topic][partition] != delayed {
+		// dropped by a revoke or the drain.
+		self.delayMutex.Unlock()
+		return
+	}
+	due, held := delayed.records, false
+	for index, record := range delayed.records {
+		if wait, ok := retryWait(record); ok {
+			// a later retry of the partition is not due yet.
+			due, delayed.records = delayed.records[:index], delayed.records[index:]
+			delayed.timer.Reset(wait)
+			held = true
+			break
+		}
+	}
+	if !held {
+		delete(self.delayed[topic], partition)
+	}
+	self.releasing.Add(1)
+	self.delayMutex.Unlock()
+
+	defer self.releasing.Done()
+	for _, record := range due {
+		self.dispatch(record)
+	}
+	if !held {
+		self.client.ResumeFetchPartitions(map[string][]int32{topic: {partition}})
+	}
+}
+
+// dropDelayed forgets the held records of partitions that were revoked or lost
+// and resumes fetching them, so they are fetched again once assigned.
+func (self *Service) dropDelayed(partitions map[string][]int32) {
+	self.delayMutex.Lock()
+	defer self.delayMutex.Unlock()
+	resumed := make(map[string][]int32)
+	for topic, items := range partitions {
+		for _, partition := range items {
+			if delayed, ok := self.delayed[topic][partition]; ok {
+				delayed.timer.Stop()
+				delete(self.delayed[topic], partition)
+				resumed[topic] = append(resumed[topic], partition)
+			}
+		}
+	}
+	if len(resumed) > 0 {
+		self.client.ResumeFetchPartitions(resumed)
+	}
+}
+
+// closeDelayed drops every held record and waits for the releases in progress.
+// Held records were never dispatched, so they are not committed and are
+// fetched again after a restart.
+func (self *Service) closeDelayed() {
+	self.delayMutex.Lock()
+	self.delayClosed = true
+	for _, partitions := range self.delayed {
+		for _, delayed := range partitions {
+			delayed.timer.Stop()
+		}
+	}
+	self.delayed = nil
+	self.delayMutex.Unlock()
+	self.releasing.Wait()
+}
+
+// retryWait returns how long a record of a retry topic must wait before it is due.
+func retryWait(record *kgo.Record) (time.Duration, bool) {
+	notBefore := headerInt(record, HeaderRetryNotBefore)
+	if notBefore == 0 {
+		return 0, false
+	}
+	wait := time.Until(time.UnixMilli(notBefore))
+	return wait, wait > 0
+}
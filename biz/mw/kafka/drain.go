@@ -0,0 +1,113 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// drain.go
+//
+// Description: ordered shutdown of the Service. Handlers get the chance to
+// finish the records that were already fetched, and what they handled is
+// committed before the client leaves the group, so a restart neither loses
+// nor needlessly repeats work.
+
+// drain stops the Service in order:
+//
+//  1. stop fetching and dispatching new records, wait for ReadLoop to return and
+//     drop the retry records held until they are due;
+//  2. close the worker channels, so workers handle their buffered records and
+//     flush partial batches;
+//  3. wait for the workers until ctx is done, then cancel the handlers still running
+//     and wait at most cancelGrace for them to return;
+//  4. commit the final offsets;
+//  5. flush the records still buffered by the producer;
+//  6. close the client.
+//
+// Step 1 is bounded the same way, so neither a handler that ignores its context nor a
+// ReadLoop blocked in a transaction keeps the shutdown from committing and closing.
+// Records whose handling did not finish are not committed and are consumed again later.
+func (self *Service) drain(ctx context.Context) {
+	begin := time.Now()
+	self.stopFetch()
+	stopped := make(chan struct{})
+	go func() {
+		// ReadLoop may be blocked handing a record to a busy worker.
+		self.loop.Wait()
+		close(stopped)
+	}()
+	loopStopped := self.waitOrCancel(ctx, stopped)
+	self.closeDelayed()
+
+	self.workerMutex.Lock()
+	workers := make([]*workerCtx, 0, len(self.workerMap))
+	for key, work := range self.workerMap {
+		if loopStopped {
+			close(work.ch)
+		} else {
+			// ReadLoop may still send to the channel; the canceled workers exit instead.
+			work.cancel()
+		}
+		workers = append(workers, work)
+		delete(self.workerMap, key)
+	}
+	self.workerMutex.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		for _, work := range workers {
+			<-work.done
+		}
+		close(drained)
+	}()
+	self.waitOrCancel(ctx, drained)
+
+	// the final commit and flush run even when the drain deadline has passed.
+	finalCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if self.managedCommit() {
+		self.commitTracked(finalCtx, nil)
+	} else if self.autoCommit {
+		if err := self.client.CommitMarkedOffsets(finalCtx); err != nil {
+			slog.Error("kafka commit offsets failed", slog.String("name", self.name), slog.String("error", err.Error()))
+		}
+	}
+	if err := self.client.Flush(finalCtx); err != nil {
+		slog.Error("kafka producer flush failed", slog.String("name", self.name), slog.String("error", err.Error()))
+	}
+	if self.session != nil {
+		self.session.Close()
+	} else {
+		self.client.Close()
+	}
+	self.cancel()
+	slog.Info("kafka service drained", slog.String("name", self.name), slog.Duration("elapsed", time.Since(begin)))
+}
+
+// cancelGrace bounds the wait for handlers after they were canceled, so a handler
+// that ignores its context cannot block the shutdown.
+const cancelGrace = 5 * time.Second
+
+// waitOrCancel waits for done, canceling the handlers once ctx is done and giving
+// them cancelGrace to return. It reports whether done was closed.
+func (self *Service) waitOrCancel(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+	}
+	if self.ctx.Err() == nil {
+		slog.Warn("kafka service drain timeout, canceling handlers", slog.String("name", self.name))
+		self.cancel()
+	}
+	timer := time.NewTimer(cancelGrace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		slog.Error("kafka service handlers did not return after cancel, continuing shutdown",
+			slog.String("name", self.name), slog.Duration("grace", cancelGrace))
+		return false
+	}
+}
@@ -2,10 +2,13 @@ package kafka
 
 import (
 	"context"
+	"log/slog"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/spf13/viper"
+	"github.com/twmb/franz-go/pkg/kadm"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/wnnce/fserv-template/config"
 )
@@ -22,18 +25,77 @@ type Service struct {
 	workerMutex *sync.Mutex
 	ctx         context.Context
 	cancel      context.CancelFunc
+	fetchCtx    context.Context
+	stopFetch   context.CancelFunc
+	loop        sync.WaitGroup
+	drainTime   time.Duration
 	once        sync.Once
+	tracker     *offsetTracker
+	middlewares []HandlerMiddleware
+	stats       sync.Map
+	// generations counts the revocations of every partition, guarded by mutex,
+	// so shared workers can skip records dispatched before a revoke.
+	generations map[string]map[int32]uint64
+	// delayed holds the records of retry topic partitions paused until they are due.
+	delayed     map[string]map[int32]*delayedPartition
+	delayMutex  sync.Mutex
+	delayClosed bool
+	releasing   sync.WaitGroup
+	// transactional is set by kafka.transactional-id. session is only set when
+	// the transactional Service also consumes in a group.
+	transactional bool
+	session       *kgo.GroupTransactSession
+	txMutex       sync.Mutex
+	cycle         atomic.Pointer[txCycle]
 }
 
 // NewService creates a new Service instance with the given name, Kafka client, and auto-commit setting.
+// Without auto-commit the Service commits handled offsets itself every 5 seconds; the client should
+// then be built with kgo.DisableAutoCommit and with hooks calling OnPartitionsRevoked and
+// OnPartitionsLost, so that handled offsets are committed before partitions move:
+//
+//	var service *kafka.Service
+//	client, err := kgo.NewClient(
+//		kgo.ConsumerGroup("enrichment"),
+//		kgo.DisableAutoCommit(),
+//		kgo.OnPartitionsRevoked(func(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+//			service.OnPartitionsRevoked(ctx, client, revoked)
+//		}),
+//		kgo.OnPartitionsLost(func(ctx context.Context, client *kgo.Client, lost map[string][]int32) {
+//			service.OnPartitionsLost(ctx, client, lost)
+//		}),
+//	)
+//	service = kafka.NewService("enrichment", client, false)
+//
+// With auto-commit, build the client with kgo.AutoCommitMarks so that only dispatched records are
+// committed. Shutdown waits up to 30 seconds for in-flight handlers.
 func NewService(name string, client *kgo.Client, autoCommit bool) *Service {
-	return &Service{
+	service := &Service{
 		name:       name,
 		client:     client,
 		autoCommit: autoCommit,
-		mutex:      &sync.Mutex{},
-		consumers:  make(map[string]*Consumer),
 	}
+	if !autoCommit {
+		service.tracker = newOffsetTracker()
+	}
+	service.init(context.Background(), 30*time.Second)
+	if !autoCommit {
+		go service.commitLoop(5 * time.Second)
+	}
+	return service
+}
+
+// init sets up the state shared by NewService and InitKafkaService.
+func (self *Service) init(ctx context.Context, drainTime time.Duration) {
+	self.mutex = &sync.Mutex{}
+	self.consumers = make(map[string]*Consumer)
+	self.generations = make(map[string]map[int32]uint64)
+	self.workerMap = make(map[string]*workerCtx)
+	self.workerMutex = &sync.Mutex{}
+	self.ctx, self.cancel = context.WithCancel(ctx)
+	self.fetchCtx, self.stopFetch = context.WithCancel(self.ctx)
+	self.drainTime = drainTime
+	self.middlewares = []HandlerMiddleware{TraceMiddleware(), RecoverMiddleware()}
 }
 
 // Client returns the underlying kgo.Client instance.
@@ -41,12 +103,13 @@ func (self *Service) Client() *kgo.Client {
 	return self.client
 }
 
-// Shutdown gracefully closes the Kafka client and cancels all consumers.
+// Shutdown drains the consumers and closes the Kafka client, waiting up to
+// kafka.shutdown-timeout for in-flight handlers. See Drain for the order of the steps.
 func (self *Service) Shutdown() {
 	self.once.Do(func() {
-		clear(self.consumers)
-		self.client.Close()
-		self.cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), self.drainTime)
+		defer cancel()
+		self.drain(ctx)
 	})
 }
 
@@ -55,37 +118,91 @@ var (
 )
 
 // InitKafkaService initializes the global Kafka Service using configuration and returns a cleanup function.
+//
+// With kafka.commit enabled, the client periodically commits the records that were dispatched to
+// a worker; records that were fetched but not dispatched when the Service stops are fetched again.
+// With kafka.commit disabled, the Service runs in managed commit mode: it commits the highest
+// contiguous handled offset of every partition each kafka.commit-interval, when partitions are
+// revoked by a rebalance and on shutdown.
+//
+// Topics declared with RegisterTopics are reconciled when kafka.topics.sync is true (the default):
+// missing topics are created, and partitions are added only when kafka.topics.increase-partitions is true.
+//
+// Every consumer handler is wrapped by TraceMiddleware and RecoverMiddleware; add more with Use.
+//
+// When kafka.transactional-id is set, the Service is transactional and kafka.commit is ignored:
+// records are only produced in transactions. ProducerWithSync, Publish and the retry and
+// dead-letter escalation go through Transact, so with a consumer group they join the
+// transaction of the handler and fail outside of one. With a consumer group the offsets are
+// committed by the transaction of every poll. Consumers then only read committed records.
 func InitKafkaService(ctx context.Context) (func(), error) {
 	brokers := viper.GetStringSlice("kafka.brokers")
-	autoCommit := config.ViperGet[bool]("kafka.commit", true)
-	client, err := kgo.NewClient(
+	group := config.ViperGet[string]("kafka.consumer-group")
+	transactionalID := config.ViperGet[string]("kafka.transactional-id")
+	// transactional offsets are committed with the transactions.
+	autoCommit := config.ViperGet[bool]("kafka.commit", true) && transactionalID == ""
+	// the rebalance hooks are registered before the service exists.
+	service := &Service{autoCommit: autoCommit, transactional: transactionalID != ""}
+	if !autoCommit && !service.transactional {
+		service.tracker = newOffsetTracker()
+	}
+	options, err := viperClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	if service.transactional {
+		options = append(options, kgo.TransactionalID(transactionalID), kgo.FetchIsolationLevel(kgo.ReadCommitted()))
+		if timeout := config.ViperGet[time.Duration]("kafka.transaction-timeout"); timeout > 0 {
+			options = append(options, kgo.TransactionTimeout(timeout))
+		}
+	}
+	options = append(options,
 		kgo.SeedBrokers(brokers...),
 		kgo.ClientID(config.ViperGet[string]("kafka.client-id")),
-		kgo.ConsumerGroup(config.ViperGet[string]("kafka.consumer-group")),
+		kgo.ConsumerGroup(group),
 		func(commit bool) kgo.Opt {
 			if !commit {
 				return kgo.DisableAutoCommit()
 			}
-			return kgo.GreedyAutoCommit()
+			// only dispatched records are committed, see dispatch.
+			return kgo.AutoCommitMarks()
 		}(autoCommit),
+		kgo.OnPartitionsRevoked(func(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+			service.OnPartitionsRevoked(ctx, client, revoked)
+		}),
+		kgo.OnPartitionsLost(func(ctx context.Context, client *kgo.Client, lost map[string][]int32) {
+			service.OnPartitionsLost(ctx, client, lost)
+		}),
 	)
+	var client *kgo.Client
+	if service.transactional && group != "" {
+		service.session, err = kgo.NewGroupTransactSession(append(options, kgo.RequireStableFetchOffsets())...)
+		if err == nil {
+			client = service.session.Client()
+		}
+	} else {
+		client, err = kgo.NewClient(options...)
+	}
 	if err != nil {
 		return nil, err
 	}
 	if err = client.Ping(ctx); err != nil {
 		return nil, err
 	}
-	childCtx, cancel := context.WithCancel(ctx)
-	defaultService = &Service{
-		client:      client,
-		autoCommit:  autoCommit,
-		mutex:       &sync.Mutex{},
-		consumers:   make(map[string]*Consumer),
-		workerMap:   make(map[string]*workerCtx),
-		workerMutex: &sync.Mutex{},
-		ctx:         childCtx,
-		cancel:      cancel,
+	if config.ViperGet[bool]("kafka.topics.sync", true) {
+		admin := kadm.NewClient(client)
+		if _, err = SyncTopics(ctx, admin, config.ViperGet[bool]("kafka.topics.increase-partitions", false)); err != nil {
+			slog.Error("kafka topic sync failed", slog.String("error", err.Error()))
+			client.Close()
+			return nil, err
+		}
+	}
+	service.client = client
+	service.init(ctx, config.ViperGet[time.Duration]("kafka.shutdown-timeout", 30*time.Second))
+	if service.managedCommit() {
+		go service.commitLoop(config.ViperGet[time.Duration]("kafka.commit-interval", 5*time.Second))
 	}
+	defaultService = service
 	return func() {
 		defaultService.Shutdown()
 	}, nil
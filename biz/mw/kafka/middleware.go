@@ -0,0 +1,161 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/wnnce/fserv-template/internal/constat"
+)
+
+// HandlerMiddleware wraps a MessageHandler with cross-cutting behavior.
+type HandlerMiddleware func(next MessageHandler) MessageHandler
+
+// HandlerStats are the measurements of one handler call.
+type HandlerStats struct {
+	Topic    string
+	Records  int
+	Duration time.Duration
+	// Lag is the time between the oldest record of the call being produced and the handler finishing.
+	Lag time.Duration
+	Err error
+}
+
+// Use returns a copy of the consumer whose Handler is wrapped by middlewares.
+// The first middleware is the outermost one.
+func (self Consumer) Use(middlewares ...HandlerMiddleware) Consumer {
+	self.Handler = chainHandler(self.Handler, middlewares)
+	return self
+}
+
+// Use adds middlewares that wrap the handler of every consumer registered afterward,
+// outside of the consumer's own middlewares.
+func (self *Service) Use(middlewares ...HandlerMiddleware) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.middlewares = append(self.middlewares, middlewares...)
+}
+
+func chainHandler(handler MessageHandler, middlewares []HandlerMiddleware) MessageHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// TraceMiddleware stores the traceId header of the records in the handler context,
+// so that logs written by the handler are correlated with the producer. Batches whose
+// records carry different traceIds, and records without one, get a new traceId.
+func TraceMiddleware() HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, client *kgo.Client, autoCommit bool, records ...*kgo.Record) error {
+			traceID := sharedTraceID(records)
+			if traceID == "" {
+				traceID = uuid.New().String()
+			}
+			ctx = context.WithValue(ctx, constat.ContextTraceKey, traceID)
+			return next(ctx, client, autoCommit, records...)
+		}
+	}
+}
+
+// RecoverMiddleware turns a handler panic into an error, so that the records are
+// handled by the retry policy instead of the panic ending the worker.
+func RecoverMiddleware() HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, client *kgo.Client, autoCommit bool, records ...*kgo.Record) (err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					slog.ErrorContext(ctx, "kafka handler panic recovered",
+						slog.String("topic", recordsTopic(records)),
+						slog.Any("error", value),
+						slog.String("debug", string(debug.Stack())),
+					)
+					err = fmt.Errorf("kafka handler panic: %v", value)
+				}
+			}()
+			return next(ctx, client, autoCommit, records...)
+		}
+	}
+}
+
+// MetricsMiddleware reports the duration and lag of every handler call to observer.
+func MetricsMiddleware(observer func(stats HandlerStats)) HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, client *kgo.Client, autoCommit bool, records ...*kgo.Record) error {
+			begin := time.Now()
+			err := next(ctx, client, autoCommit, records...)
+			end := time.Now()
+			stats := HandlerStats{
+				Topic:    recordsTopic(records),
+				Records:  len(records),
+				Duration: end.Sub(begin),
+				Err:      err,
+			}
+			for _, record := range records {
+				if !record.Timestamp.IsZero() {
+					stats.Lag = max(stats.Lag, end.Sub(record.Timestamp))
+				}
+			}
+			observer(stats)
+			return err
+		}
+	}
+}
+
+// LoggingMiddleware logs every handled record with the duration of its handler call,
+// at info level when it succeeded and at error level when it failed.
+func LoggingMiddleware() HandlerMiddleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, client *kgo.Client, autoCommit bool, records ...*kgo.Record) error {
+			begin := time.Now()
+			err := next(ctx, client, autoCommit, records...)
+			latency := time.Since(begin)
+			failed := make(map[*kgo.Record]error, len(records))
+			for _, recordErr := range failedRecords(records, err) {
+				failed[recordErr.Record] = recordErr.Err
+			}
+			for _, record := range records {
+				attrs := []any{
+					slog.Group("data",
+						slog.String("topic", record.Topic),
+						slog.Int("partition", int(record.Partition)),
+						slog.Int64("offset", record.Offset),
+						slog.String("key", string(record.Key)),
+					),
+					slog.Int64("latency", latency.Milliseconds()),
+				}
+				if recordErr, ok := failed[record]; ok {
+					slog.ErrorContext(ctx, "kafka record handle failed", append(attrs, slog.String("error", recordErr.Error()))...)
+					continue
+				}
+				slog.InfoContext(ctx, "kafka record handled", attrs...)
+			}
+			return err
+		}
+	}
+}
+
+// sharedTraceID returns the traceId header carried by every record, or an empty string.
+func sharedTraceID(records []*kgo.Record) string {
+	traceID := ""
+	for i, record := range records {
+		value := headerValue(record, constat.ContextTraceKey)
+		if value == "" || (i > 0 && value != traceID) {
+			return ""
+		}
+		traceID = value
+	}
+	return traceID
+}
+
+func recordsTopic(records []*kgo.Record) string {
+	if len(records) == 0 {
+		return ""
+	}
+	return records[0].Topic
+}
@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/wnnce/fserv-template/internal/constat"
+)
+
+func tracedRecord(offset int64, traceID string) *kgo.Record {
+	record := &kgo.Record{Topic: "orders", Offset: offset}
+	if traceID != "" {
+		record.Headers = []kgo.RecordHeader{{Key: constat.ContextTraceKey, Value: []byte(traceID)}}
+	}
+	return record
+}
+
+func TestSharedTraceID(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []*kgo.Record
+		expected string
+	}{
+		{name: "empty", records: nil, expected: ""},
+		{name: "single", records: []*kgo.Record{tracedRecord(1, "a")}, expected: "a"},
+		{name: "shared", records: []*kgo.Record{tracedRecord(1, "a"), tracedRecord(2, "a")}, expected: "a"},
+		{name: "mixed", records: []*kgo.Record{tracedRecord(1, "a"), tracedRecord(2, "b")}, expected: ""},
+		{name: "missing", records: []*kgo.Record{tracedRecord(1, "a"), tracedRecord(2, "")}, expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := sharedTraceID(tt.records); result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestTraceMiddleware_MixedBatchGetsNewID(t *testing.T) {
+	var traceID string
+	handler := TraceMiddleware()(func(ctx context.Context, _ *kgo.Client, _ bool, _ ...*kgo.Record) error {
+		traceID, _ = ctx.Value(constat.ContextTraceKey).(string)
+		return nil
+	})
+	_ = handler(context.Background(), nil, false, tracedRecord(1, "a"), tracedRecord(2, "b"))
+	if traceID == "" || traceID == "a" || traceID == "b" {
+		t.Errorf("expected a new traceId, got %q", traceID)
+	}
+	_ = handler(context.Background(), nil, false, tracedRecord(1, "a"), tracedRecord(2, "a"))
+	if traceID != "a" {
+		t.Errorf("expected the shared traceId a, got %q", traceID)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	handler := RecoverMiddleware()(func(context.Context, *kgo.Client, bool, ...*kgo.Record) error {
+		panic("boom")
+	})
+	err := handler(context.Background(), nil, false, tracedRecord(1, ""))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the panic as an error, got %v", err)
+	}
+}
+
+func TestLoggingMiddleware_RecordErrors(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buffer, nil)))
+	defer slog.SetDefault(previous)
+
+	records := []*kgo.Record{tracedRecord(1, ""), tracedRecord(2, ""), tracedRecord(3, "")}
+	failure := RecordErrors{{Record: records[1], Err: errors.New("invalid")}}
+	handler := LoggingMiddleware()(func(context.Context, *kgo.Client, bool, ...*kgo.Record) error {
+		return failure
+	})
+	var recordErrors RecordErrors
+	if err := handler(context.Background(), nil, false, records...); !errors.As(err, &recordErrors) || len(recordErrors) != 1 {
+		t.Fatalf("expected the record errors to be returned, got %v", err)
+	}
+	levels := make(map[int64]string)
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var entry struct {
+			Level string `json:"level"`
+			Data  struct {
+				Offset int64 `json:"offset"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		levels[entry.Data.Offset] = entry.Level
+	}
+	expected := map[int64]string{1: "INFO", 2: "ERROR", 3: "INFO"}
+	for offset, level := range expected {
+		if levels[offset] != level {
+			t.Errorf("offset %d: expected %s, got %s", offset, level, levels[offset])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+	"github.com/wnnce/fserv-template/config"
+)
+
+// options.go
+//
+// Description: builds the kgo client options from the kafka.* configuration.
+// Keys that are not set keep the franz-go defaults.
+
+// viperClientOptions returns the connection, consumer and producer options configured under kafka.*.
+func viperClientOptions() ([]kgo.Opt, error) {
+	opts := make([]kgo.Opt, 0, 16)
+	tlsConfig, err := config.ViperTLSConfig("kafka.tls")
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+	saslOpt, err := viperSASL()
+	if err != nil {
+		return nil, err
+	}
+	if saslOpt != nil {
+		opts = append(opts, saslOpt)
+	}
+	consumerOpts, err := viperConsumerOptions()
+	if err != nil {
+		return nil, err
+	}
+	producerOpts, err := viperProducerOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, consumerOpts...)
+	return append(opts, producerOpts...), nil
+}
+
+// viperSASL returns the SASL option selected by kafka.sasl.mechanism: plain,
+// scram-sha-256 or scram-sha-512.
+func viperSASL() (kgo.Opt, error) {
+	mechanism := strings.ToLower(config.ViperGet[string]("kafka.sasl.mechanism"))
+	username := config.ViperGet[string]("kafka.sasl.username")
+	password := config.ViperGet[string]("kafka.sasl.password")
+	switch mechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return kgo.SASL(plain.Auth{User: username, Pass: password}.AsMechanism()), nil
+	case "scram-sha-256":
+		return kgo.SASL(scram.Auth{User: username, Pass: password}.AsSha256Mechanism()), nil
+	case "scram-sha-512":
+		return kgo.SASL(scram.Auth{User: username, Pass: password}.AsSha512Mechanism()), nil
+	default:
+		return nil, fmt.Errorf("unknown kafka sasl mechanism %q", mechanism)
+	}
+}
+
+// viperConsumerOptions returns the group, fetch and start offset options.
+func viperConsumerOptions() ([]kgo.Opt, error) {
+	opts := make([]kgo.Opt, 0, 8)
+	switch balancer := config.ViperGet[string]("kafka.balancer"); balancer {
+	case "":
+	case "cooperative-sticky":
+		opts = append(opts, kgo.Balancers(kgo.CooperativeStickyBalancer()))
+	case "sticky":
+		opts = append(opts, kgo.Balancers(kgo.StickyBalancer()))
+	case "round-robin":
+		opts = append(opts, kgo.Balancers(kgo.RoundRobinBalancer()))
+	case "range":
+		opts = append(opts, kgo.Balancers(kgo.RangeBalancer()))
+	default:
+		return nil, fmt.Errorf("unknown kafka balancer %q", balancer)
+	}
+	if value := config.ViperGet[time.Duration]("kafka.session-timeout"); value > 0 {
+		opts = append(opts, kgo.SessionTimeout(value))
+	}
+	if value := config.ViperGet[time.Duration]("kafka.rebalance-timeout"); value > 0 {
+		opts = append(opts, kgo.RebalanceTimeout(value))
+	}
+	if value := config.ViperGet[time.Duration]("kafka.heartbeat-interval"); value > 0 {
+		opts = append(opts, kgo.HeartbeatInterval(value))
+	}
+	if value := config.ViperGet[int32]("kafka.fetch.min-bytes"); value > 0 {
+		opts = append(opts, kgo.FetchMinBytes(value))
+	}
+	if value := config.ViperGet[int32]("kafka.fetch.max-bytes"); value > 0 {
+		opts = append(opts, kgo.FetchMaxBytes(value))
+	}
+	if value := config.ViperGet[int32]("kafka.fetch.max-partition-bytes"); value > 0 {
+		opts = append(opts, kgo.FetchMaxPartitionBytes(value))
+	}
+	if value := config.ViperGet[time.Duration]("kafka.fetch.max-wait"); value > 0 {
+		opts = append(opts, kgo.FetchMaxWait(value))
+	}
+	// start-offset applies when the group has no committed offset: earliest,
+	// latest or an RFC 3339 timestamp.
+	switch startOffset := config.ViperGet[string]("kafka.start-offset"); startOffset {
+	case "", "earliest":
+	case "latest":
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
+	default:
+		timestamp, err := time.Parse(time.RFC3339, startOffset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka start-offset %q: %w", startOffset, err)
+		}
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AfterMilli(timestamp.UnixMilli())))
+	}
+	return opts, nil
+}
+
+// viperProducerOptions returns the acks, idempotence, batching and partitioning options.
+func viperProducerOptions() ([]kgo.Opt, error) {
+	opts := make([]kgo.Opt, 0, 6)
+	acks := config.ViperGet[string]("kafka.producer.acks", "all")
+	switch acks {
+	case "all":
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case "leader":
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case "none":
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	default:
+		return nil, fmt.Errorf("unknown kafka producer acks %q", acks)
+	}
+	// idempotent writes require acks from all in-sync replicas, so they
+	// default to off for weaker acks.
+	idempotent := config.ViperGet[bool]("kafka.producer.idempotent", acks == "all")
+	if idempotent && acks != "all" {
+		return nil, errors.New("kafka idempotent producer requires kafka.producer.acks to be all")
+	}
+	if !idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+	if value := config.ViperGet[time.Duration]("kafka.producer.linger"); value > 0 {
+		opts = append(opts, kgo.ProducerLinger(value))
+	}
+	if value := config.ViperGet[int32]("kafka.producer.max-batch-bytes"); value > 0 {
+		opts = append(opts, kgo.ProducerBatchMaxBytes(value))
+	}
+	if names := config.ViperGet[[]string]("kafka.producer.compression"); len(names) > 0 {
+		codecs := make([]kgo.CompressionCodec, 0, len(names))
+		for _, name := range names {
+			codec, err := compressionCodec(name)
+			if err != nil {
+				return nil, err
+			}
+			codecs = append(codecs, codec)
+		}
+		opts = append(opts, kgo.ProducerBatchCompression(codecs...))
+	}
+	switch partitioner := config.ViperGet[string]("kafka.producer.partitioner"); partitioner {
+	case "", "sticky-key":
+	case "round-robin":
+		opts = append(opts, kgo.RecordPartitioner(kgo.RoundRobinPartitioner()))
+	case "least-backup":
+		opts = append(opts, kgo.RecordPartitioner(kgo.LeastBackupPartitioner()))
+	case "manual":
+		opts = append(opts, kgo.RecordPartitioner(kgo.ManualPartitioner()))
+	default:
+		return nil, fmt.Errorf("unknown kafka producer partitioner %q", partitioner)
+	}
+	return opts, nil
+}
+
+func compressionCodec(name string) (kgo.CompressionCodec, error) {
+	switch name {
+	case "none":
+		return kgo.NoCompression(), nil
+	case "gzip":
+		return kgo.GzipCompression(), nil
+	case "snappy":
+		return kgo.SnappyCompression(), nil
+	case "lz4":
+		return kgo.Lz4Compression(), nil
+	case "zstd":
+		return kgo.ZstdCompression(), nil
+	default:
+		return kgo.CompressionCodec{}, fmt.Errorf("unknown kafka compression codec %q", name)
+	}
+}
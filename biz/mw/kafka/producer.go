@@ -15,6 +15,7 @@ import (
 
 // ProducerWithSync sends a message to the specified topic synchronously.
 // The value is automatically serialized, and traceId is injected into headers if present in context.
+// A transactional Service produces the message through Transact.
 func (self *Service) ProducerWithSync(ctx context.Context, topic string, key []byte, value any, headers ...kgo.RecordHeader) error {
 	body, err := self.valueProtocol(value)
 	if err != nil {
@@ -32,12 +33,13 @@ func (self *Service) ProducerWithSync(ctx context.Context, topic string, key []b
 		slog.Any("key", key),
 		slog.Any("value", value),
 	))
-	return self.client.ProduceSync(ctx, record).FirstErr()
+	return self.produceSync(ctx, record)
 }
 
 // ProducerWithAsync sends a message to the specified topic asynchronously.
 // The value is automatically serialized, and traceId is injected into headers if present in context.
-// The callback is invoked upon completion.
+// The callback is invoked upon completion. A transactional Service only accepts it
+// inside a transaction; use ProducerWithSync or Transact instead.
 func (self *Service) ProducerWithAsync(ctx context.Context, topic string, key []byte, value any,
 	callback func(record *kgo.Record, err error), headers ...kgo.RecordHeader) {
 	body, err := self.valueProtocol(value)
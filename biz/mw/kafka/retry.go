@@ -0,0 +1,293 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Headers added to records that are moved to a retry or dead-letter topic.
+const (
+	HeaderError             = "x-error"
+	HeaderAttempts          = "x-attempts"
+	HeaderRetryStage        = "x-retry-stage"
+	HeaderRetryNotBefore    = "x-retry-not-before"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+)
+
+// RecordError reports the failure of a single record in a batch.
+type RecordError struct {
+	Record *kgo.Record
+	Err    error
+}
+
+// RecordErrors lets a batch handler report which records failed. Records not
+// listed are treated as handled, while any other error returned by a handler
+// marks every record of the call as failed.
+type RecordErrors []RecordError
+
+func (self RecordErrors) Error() string {
+	messages := make([]string, 0, len(self))
+	for _, recordErr := range self {
+		messages = append(messages, recordErr.Record.Topic+"/"+strconv.Itoa(int(recordErr.Record.Partition))+"@"+
+			strconv.FormatInt(recordErr.Record.Offset, 10)+": "+recordErr.Err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// RetryTopic is a topic that failed records are republished to and consumed
+// again from once Delay has passed since they were republished. While a record
+// is not due, fetching its partition is paused; other partitions and topics are
+// not held back.
+type RetryTopic struct {
+	Topic string
+	Delay time.Duration
+}
+
+// RetryPolicy decides what happens to records whose handler fails.
+//
+// A failed record is first retried in process up to MaxAttempts calls in
+// total, waiting Backoff before the first retry and multiplying the wait by
+// Multiplier up to MaxBackoff. It is then republished to each RetryTopic in
+// turn, and finally to DeadLetterTopic. Without a DeadLetterTopic, exhausted
+// records are logged and dropped.
+//
+// Example:
+//
+//	consumer := kafka.NewConsumer("payments", false, 0, HandlePayment).WithRetry(
+//		kafka.BackoffRetry(3, 100*time.Millisecond, time.Second).
+//			WithRetryTopics(kafka.RetryTopic{Topic: "payments.retry-1m", Delay: time.Minute}).
+//			WithDeadLetter("payments.dlt"),
+//	)
+type RetryPolicy struct {
+	MaxAttempts     int
+	Backoff         time.Duration
+	MaxBackoff      time.Duration
+	Multiplier      float64
+	RetryTopics     []RetryTopic
+	DeadLetterTopic string
+}
+
+// ImmediateRetry calls the handler up to attempts times without waiting.
+func ImmediateRetry(attempts int) RetryPolicy {
+	return RetryPolicy{MaxAttempts: attempts}
+}
+
+// BackoffRetry calls the handler up to attempts times, doubling the wait
+// between calls from initial up to maxBackoff.
+func BackoffRetry(attempts int, initial, maxBackoff time.Duration) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: attempts,
+		Backoff:     initial,
+		MaxBackoff:  maxBackoff,
+		Multiplier:  2,
+	}
+}
+
+// WithRetryTopics returns a copy of the policy that republishes exhausted records to topics in order.
+func (self RetryPolicy) WithRetryTopics(topics ...RetryTopic) RetryPolicy {
+	self.RetryTopics = topics
+	return self
+}
+
+// WithDeadLetter returns a copy of the policy that finally moves exhausted records to topic.
+func (self RetryPolicy) WithDeadLetter(topic string) RetryPolicy {
+	self.DeadLetterTopic = topic
+	return self
+}
+
+// backoff returns the wait before the given retry, starting at 1.
+func (self RetryPolicy) backoff(retry int) time.Duration {
+	wait := self.Backoff
+	for i := 1; i < retry && wait > 0; i++ {
+		wait = time.Duration(float64(wait) * max(self.Multiplier, 1))
+		if self.MaxBackoff > 0 && wait >= self.MaxBackoff {
+			return self.MaxBackoff
+		}
+	}
+	return wait
+}
+
+// topics returns the retry topics consumed on behalf of the consumer.
+func (self RetryPolicy) topics() []string {
+	topics := make([]string, 0, len(self.RetryTopics))
+	for _, retryTopic := range self.RetryTopics {
+		if retryTopic.Topic != "" {
+			topics = append(topics, retryTopic.Topic)
+		}
+	}
+	return topics
+}
+
+// handle calls the consumer handler with the retry policy of the consumer and
+// escalates records that still fail to the next retry topic or dead-letter
+// topic. It returns the records that could neither be handled nor escalated,
+// which is empty unless producing failed or ctx was canceled.
+func (self *Service) handle(ctx context.Context, consumer *Consumer, records ...*kgo.Record) []*kgo.Record {
+	if len(records) == 0 {
+		return nil
+	}
+	// a group transaction spans the whole poll, so its retry records are not held
+	// back by ReadLoop and wait here instead.
+	if self.session != nil && !waitRetryDelay(ctx, records) {
+		return records
+	}
+	policy := consumer.Retry
+	pending := records
+	var failed []RecordError
+	for attempt := 1; ; attempt++ {
+		failed = failedRecords(pending, consumer.Handler(ctx, self.client, self.autoCommit, pending...))
+		if len(failed) == 0 {
+			return nil
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+		if wait := policy.backoff(attempt); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return recordsOf(failed)
+			case <-time.After(wait):
+			}
+		}
+		pending = recordsOf(failed)
+	}
+	if ctx.Err() != nil {
+		return recordsOf(failed)
+	}
+	self.topicStats(records[0].Topic).fail(failed)
+	unhandled := make([]*kgo.Record, 0)
+	for _, recordErr := range failed {
+		if err := self.escalate(ctx, consumer, recordErr); err != nil {
+			slog.ErrorContext(ctx, "kafka escalate failed record error", slog.Group("data",
+				slog.String("topic", recordErr.Record.Topic),
+				slog.Int("partition", int(recordErr.Record.Partition)),
+				slog.Int64("offset", recordErr.Record.Offset),
+			), slog.String("error", err.Error()))
+			unhandled = append(unhandled, recordErr.Record)
+		}
+	}
+	return unhandled
+}
+
+// escalate republishes a failed record to the next retry topic, or to the
+// dead-letter topic once every retry topic has been tried.
+func (self *Service) escalate(ctx context.Context, consumer *Consumer, recordErr RecordError) error {
+	record := recordErr.Record
+	attempts := headerInt(record, HeaderAttempts) + int64(max(consumer.Retry.MaxAttempts, 1))
+	stage := int(headerInt(record, HeaderRetryStage))
+	headers := make([]kgo.RecordHeader, 0, len(record.Headers)+7)
+	for _, header := range record.Headers {
+		switch header.Key {
+		case HeaderError, HeaderAttempts, HeaderRetryStage, HeaderRetryNotBefore:
+			continue
+		}
+		headers = append(headers, header)
+	}
+	if headerValue(record, HeaderOriginalTopic) == "" {
+		headers = append(headers,
+			kgo.RecordHeader{Key: HeaderOriginalTopic, Value: []byte(record.Topic)},
+			kgo.RecordHeader{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(int(record.Partition)))},
+			kgo.RecordHeader{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(record.Offset, 10))},
+		)
+	}
+	headers = append(headers,
+		kgo.RecordHeader{Key: HeaderError, Value: []byte(recordErr.Err.Error())},
+		kgo.RecordHeader{Key: HeaderAttempts, Value: []byte(strconv.FormatInt(attempts, 10))},
+	)
+	target := consumer.Retry.DeadLetterTopic
+	if stage < len(consumer.Retry.RetryTopics) {
+		retryTopic := consumer.Retry.RetryTopics[stage]
+		target = retryTopic.Topic
+		notBefore := time.Now().Add(retryTopic.Delay).UnixMilli()
+		headers = append(headers,
+			kgo.RecordHeader{Key: HeaderRetryStage, Value: []byte(strconv.Itoa(stage + 1))},
+			kgo.RecordHeader{Key: HeaderRetryNotBefore, Value: []byte(strconv.FormatInt(notBefore, 10))},
+		)
+	}
+	if target == "" {
+		slog.ErrorContext(ctx, "kafka record handling failed, record dropped", slog.Group("data",
+			slog.String("topic", record.Topic),
+			slog.Int("partition", int(record.Partition)),
+			slog.Int64("offset", record.Offset),
+			slog.Int64("attempts", attempts),
+		), slog.String("error", recordErr.Err.Error()))
+		return nil
+	}
+	slog.WarnContext(ctx, "kafka record handling failed, record republished", slog.Group("data",
+		slog.String("topic", record.Topic),
+		slog.Int64("offset", record.Offset),
+		slog.String("target", target),
+		slog.Int64("attempts", attempts),
+	), slog.String("error", recordErr.Err.Error()))
+	return self.produceSync(ctx, &kgo.Record{
+		Topic:   target,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: headers,
+	})
+}
+
+// waitRetryDelay waits until every record from a retry topic is due. It
+// returns false if ctx is canceled first.
+func waitRetryDelay(ctx context.Context, records []*kgo.Record) bool {
+	notBefore := int64(0)
+	for _, record := range records {
+		notBefore = max(notBefore, headerInt(record, HeaderRetryNotBefore))
+	}
+	wait := time.Until(time.UnixMilli(notBefore))
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+// failedRecords maps a handler error to the records it applies to.
+func failedRecords(records []*kgo.Record, err error) []RecordError {
+	if err == nil {
+		return nil
+	}
+	var recordErrors RecordErrors
+	if errors.As(err, &recordErrors) {
+		return recordErrors
+	}
+	result := make([]RecordError, 0, len(records))
+	for _, record := range records {
+		result = append(result, RecordError{Record: record, Err: err})
+	}
+	return result
+}
+
+func recordsOf(recordErrors []RecordError) []*kgo.Record {
+	records := make([]*kgo.Record, 0, len(recordErrors))
+	for _, recordErr := range recordErrors {
+		records = append(records, recordErr.Record)
+	}
+	return records
+}
+
+func headerValue(record *kgo.Record, key string) string {
+	for _, header := range record.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func headerInt(record *kgo.Record, key string) int64 {
+	value, _ := strconv.ParseInt(headerValue(record, key), 10, 64)
+	return value
+}
@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		retry    int
+		expected time.Duration
+	}{
+		{name: "immediate", policy: ImmediateRetry(3), retry: 2, expected: 0},
+		{name: "first retry", policy: BackoffRetry(5, 100*time.Millisecond, time.Second), retry: 1, expected: 100 * time.Millisecond},
+		{name: "doubled", policy: BackoffRetry(5, 100*time.Millisecond, time.Second), retry: 3, expected: 400 * time.Millisecond},
+		{name: "capped", policy: BackoffRetry(10, 100*time.Millisecond, time.Second), retry: 6, expected: time.Second},
+		{name: "no cap", policy: BackoffRetry(10, 100*time.Millisecond, 0), retry: 6, expected: 3200 * time.Millisecond},
+		{name: "multiplier below one", policy: RetryPolicy{Backoff: time.Second, Multiplier: 0.5}, retry: 4, expected: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.policy.backoff(tt.retry); result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	notBefore := func(at time.Time) *kgo.Record {
+		return &kgo.Record{Headers: []kgo.RecordHeader{
+			{Key: HeaderRetryNotBefore, Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))},
+		}}
+	}
+	if _, ok := retryWait(&kgo.Record{}); ok {
+		t.Error("expected a record without the header to be due")
+	}
+	if _, ok := retryWait(notBefore(time.Now().Add(-time.Second))); ok {
+		t.Error("expected a past record to be due")
+	}
+	wait, ok := retryWait(notBefore(time.Now().Add(time.Minute)))
+	if !ok || wait <= 50*time.Second || wait > time.Minute {
+		t.Errorf("expected a wait of about a minute, got %v %v", wait, ok)
+	}
+}
@@ -0,0 +1,208 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrConsumerNotFound is returned by Pause, Resume and TopicStatus for topics without a registered consumer.
+var ErrConsumerNotFound = errors.New("kafka: consumer not found")
+
+// topicStats are the runtime counters of one consumed topic.
+type topicStats struct {
+	paused      atomic.Bool
+	processed   atomic.Int64
+	failed      atomic.Int64
+	mutex       sync.Mutex
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// fail records records that exhausted their in-process retries.
+func (self *topicStats) fail(failed []RecordError) {
+	if len(failed) == 0 {
+		return
+	}
+	self.failed.Add(int64(len(failed)))
+	self.mutex.Lock()
+	self.lastError = failed[len(failed)-1].Err.Error()
+	self.lastErrorAt = time.Now()
+	self.mutex.Unlock()
+}
+
+// PartitionLag is the consumer group position of one partition.
+type PartitionLag struct {
+	Partition int32 `json:"partition"`
+	Committed int64 `json:"committed"`
+	End       int64 `json:"end"`
+	Lag       int64 `json:"lag"`
+}
+
+// TopicStatus is the runtime state of the consumer of one topic. Processed
+// counts records handled successfully or escalated by the retry policy, and
+// Failed counts records that exhausted their in-process retries.
+type TopicStatus struct {
+	Topic       string         `json:"topic"`
+	Running     bool           `json:"running"`
+	Paused      bool           `json:"paused"`
+	InFlight    int64          `json:"inFlight"`
+	Processed   int64          `json:"processed"`
+	Failed      int64          `json:"failed"`
+	LastError   string         `json:"lastError,omitempty"`
+	LastErrorAt *time.Time     `json:"lastErrorAt,omitempty"`
+	Partitions  []PartitionLag `json:"partitions"`
+}
+
+// topicStats returns the counters of topic, creating them if needed.
+func (self *Service) topicStats(topic string) *topicStats {
+	if stats, ok := self.stats.Load(topic); ok {
+		return stats.(*topicStats)
+	}
+	stats, _ := self.stats.LoadOrStore(topic, &topicStats{})
+	return stats.(*topicStats)
+}
+
+// Pause stops fetching topic and the retry topics of its consumer until Resume
+// is called. Records that were already fetched are still handled.
+func (self *Service) Pause(topic string) error {
+	topics, err := self.consumerTopics(topic)
+	if err != nil {
+		return err
+	}
+	self.client.PauseFetchTopics(topics...)
+	for _, item := range topics {
+		self.topicStats(item).paused.Store(true)
+	}
+	return nil
+}
+
+// Resume resumes fetching topic and the retry topics of its consumer.
+func (self *Service) Resume(topic string) error {
+	topics, err := self.consumerTopics(topic)
+	if err != nil {
+		return err
+	}
+	self.client.ResumeFetchTopics(topics...)
+	for _, item := range topics {
+		self.topicStats(item).paused.Store(false)
+	}
+	return nil
+}
+
+// Status returns the state of every consumed topic, sorted by topic. Partition
+// lag is only reported when the service belongs to a consumer group.
+func (self *Service) Status(ctx context.Context) ([]TopicStatus, error) {
+	self.mutex.Lock()
+	topics := make([]string, 0, len(self.consumers))
+	for topic := range self.consumers {
+		topics = append(topics, topic)
+	}
+	self.mutex.Unlock()
+	sort.Strings(topics)
+	return self.status(ctx, topics)
+}
+
+// TopicStatus returns the state of a single consumed topic.
+func (self *Service) TopicStatus(ctx context.Context, topic string) (TopicStatus, error) {
+	self.mutex.Lock()
+	_, ok := self.consumers[topic]
+	self.mutex.Unlock()
+	if !ok {
+		return TopicStatus{}, ErrConsumerNotFound
+	}
+	result, err := self.status(ctx, []string{topic})
+	if err != nil {
+		return TopicStatus{}, err
+	}
+	return result[0], nil
+}
+
+func (self *Service) status(ctx context.Context, topics []string) ([]TopicStatus, error) {
+	lags, err := self.groupLag(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inFlight := self.inFlight()
+	result := make([]TopicStatus, 0, len(topics))
+	for _, topic := range topics {
+		stats := self.topicStats(topic)
+		status := TopicStatus{
+			Topic:      topic,
+			Running:    self.running.Load() && !stats.paused.Load(),
+			Paused:     stats.paused.Load(),
+			InFlight:   inFlight[topic],
+			Processed:  stats.processed.Load(),
+			Failed:     stats.failed.Load(),
+			Partitions: make([]PartitionLag, 0),
+		}
+		stats.mutex.Lock()
+		if stats.lastError != "" {
+			lastErrorAt := stats.lastErrorAt
+			status.LastError, status.LastErrorAt = stats.lastError, &lastErrorAt
+		}
+		stats.mutex.Unlock()
+		for _, lag := range lags[topic] {
+			status.Partitions = append(status.Partitions, PartitionLag{
+				Partition: lag.Partition,
+				Committed: lag.Commit.At,
+				End:       lag.End.Offset,
+				Lag:       lag.Lag,
+			})
+		}
+		sort.Slice(status.Partitions, func(i, j int) bool {
+			return status.Partitions[i].Partition < status.Partitions[j].Partition
+		})
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// inFlight returns the number of records per topic that were dispatched to a
+// worker and whose handling has not finished.
+func (self *Service) inFlight() map[string]int64 {
+	self.workerMutex.Lock()
+	defer self.workerMutex.Unlock()
+	result := make(map[string]int64)
+	for _, work := range self.workerMap {
+		result[work.topic] += int64(len(work.ch)) + work.pending.Load()
+	}
+	return result
+}
+
+// groupLag returns the lag of the consumer group of the service.
+func (self *Service) groupLag(ctx context.Context) (kadm.GroupLag, error) {
+	group, _ := self.client.OptValue(kgo.ConsumerGroup).(string)
+	if group == "" {
+		return nil, nil
+	}
+	lags, err := kadm.NewClient(self.client).Lag(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	lag, ok := lags[group]
+	if !ok {
+		return nil, nil
+	}
+	if err = lag.Error(); err != nil {
+		return nil, err
+	}
+	return lag.Lag, nil
+}
+
+// consumerTopics returns topic and the retry topics of its consumer.
+func (self *Service) consumerTopics(topic string) ([]string, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	consumer, ok := self.consumers[topic]
+	if !ok {
+		return nil, ErrConsumerNotFound
+	}
+	return append([]string{consumer.Topic}, consumer.Retry.topics()...), nil
+}
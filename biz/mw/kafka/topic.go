@@ -0,0 +1,245 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/wnnce/fserv-template/config"
+)
+
+// Topic drift types reported by DiffTopics.
+const (
+	TopicMissing            = "missing"     // declared but not present in the cluster
+	TopicPartitionsMismatch = "partitions"  // present with a different partition count
+	TopicReplicationDiffers = "replication" // present with a different replication factor
+)
+
+var (
+	topicMutex    sync.Mutex
+	topicRegistry = make(map[string]*Topic)
+)
+
+// Topic is a declarative topic definition built with a fluent API.
+//
+// Example:
+//
+//	kafka.RegisterTopics(
+//		kafka.NewTopic("orders").Partitions(12).ReplicationFactor(3).Retention(7*24*time.Hour),
+//		kafka.NewTopic("order-snapshots").Partitions(12).Compact(),
+//	)
+type Topic struct {
+	name              string
+	partitions        int32
+	replicationFactor int16
+	configs           map[string]*string
+}
+
+// NewTopic creates a topic definition that uses the broker defaults for the
+// partition count, the replication factor and every config.
+func NewTopic(name string) *Topic {
+	return &Topic{
+		name:              name,
+		partitions:        -1,
+		replicationFactor: -1,
+		configs:           make(map[string]*string),
+	}
+}
+
+// Partitions sets the partition count.
+func (self *Topic) Partitions(partitions int32) *Topic {
+	self.partitions = partitions
+	return self
+}
+
+// ReplicationFactor sets the number of replicas of every partition.
+func (self *Topic) ReplicationFactor(replicationFactor int16) *Topic {
+	self.replicationFactor = replicationFactor
+	return self
+}
+
+// Config sets a topic config, e.g. "min.insync.replicas".
+func (self *Topic) Config(key, value string) *Topic {
+	self.configs[key] = kadm.StringPtr(value)
+	return self
+}
+
+// Retention sets retention.ms, deleting records older than retention.
+func (self *Topic) Retention(retention time.Duration) *Topic {
+	return self.Config("retention.ms", strconv.FormatInt(retention.Milliseconds(), 10))
+}
+
+// Compact sets cleanup.policy to compact, keeping the latest record of every key.
+func (self *Topic) Compact() *Topic {
+	return self.Config("cleanup.policy", "compact")
+}
+
+// Name returns the topic name.
+func (self *Topic) Name() string {
+	return self.name
+}
+
+// RegisterTopics declares topics. It is usually called from an init function
+// next to the consumer of the topic, and the declarations are reconciled by
+// InitKafkaService. Topic configs only apply when the topic is created.
+func RegisterTopics(topics ...*Topic) {
+	topicMutex.Lock()
+	defer topicMutex.Unlock()
+	for _, topic := range topics {
+		if topic == nil || topic.name == "" {
+			continue
+		}
+		topicRegistry[topic.name] = topic
+	}
+}
+
+// TopicDiff describes a single difference between a declared topic and the cluster.
+type TopicDiff struct {
+	Topic  string
+	Type   string
+	Detail string
+}
+
+// DiffTopics compares the declared topics with the cluster, without changing anything.
+func DiffTopics(ctx context.Context, admin *kadm.Client) ([]TopicDiff, error) {
+	declared := declaredTopics()
+	if len(declared) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(declared))
+	for _, topic := range declared {
+		names = append(names, topic.name)
+	}
+	details, err := admin.ListTopics(ctx, names...)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]TopicDiff, 0)
+	for _, topic := range declared {
+		detail, ok := details[topic.name]
+		if !ok || errors.Is(detail.Err, kerr.UnknownTopicOrPartition) {
+			diffs = append(diffs, TopicDiff{Topic: topic.name, Type: TopicMissing, Detail: "create"})
+			continue
+		}
+		if detail.Err != nil {
+			return nil, detail.Err
+		}
+		if actual := int32(len(detail.Partitions)); topic.partitions > 0 && actual != topic.partitions {
+			diffs = append(diffs, TopicDiff{Topic: topic.name, Type: TopicPartitionsMismatch,
+				Detail: "declared " + strconv.Itoa(int(topic.partitions)) + ", actual " + strconv.Itoa(int(actual))})
+		}
+		if actual := detail.Partitions.NumReplicas(); topic.replicationFactor > 0 && actual != int(topic.replicationFactor) {
+			diffs = append(diffs, TopicDiff{Topic: topic.name, Type: TopicReplicationDiffers,
+				Detail: "declared " + strconv.Itoa(int(topic.replicationFactor)) + ", actual " + strconv.Itoa(actual)})
+		}
+	}
+	return diffs, nil
+}
+
+// SyncTopics creates missing topics. Topics with fewer partitions than declared
+// are only reported unless increasePartitions is true, since adding partitions
+// changes the partition of existing keys. Topics with more partitions than
+// declared and replication differences are always only reported. It returns the
+// drift found before reconciling.
+func SyncTopics(ctx context.Context, admin *kadm.Client, increasePartitions bool) ([]TopicDiff, error) {
+	diffs, err := DiffTopics(ctx, admin)
+	if err != nil {
+		return nil, err
+	}
+	for _, diff := range diffs {
+		topic := declaredTopic(diff.Topic)
+		switch diff.Type {
+		case TopicMissing:
+			var responses kadm.CreateTopicResponses
+			responses, err = admin.CreateTopics(ctx, topic.partitions, topic.replicationFactor, topic.configs, topic.name)
+			if err == nil {
+				err = responses.Error()
+			}
+			if errors.Is(err, kerr.TopicAlreadyExists) {
+				// created concurrently by another instance.
+				err = nil
+			}
+		case TopicPartitionsMismatch:
+			details, listErr := admin.ListTopics(ctx, topic.name)
+			if listErr != nil {
+				return nil, listErr
+			}
+			if !increasePartitions || int32(len(details[topic.name].Partitions)) > topic.partitions {
+				slog.Warn("kafka topic partition count differs from declaration", slog.Group("data",
+					slog.String("topic", diff.Topic),
+					slog.String("detail", diff.Detail),
+				))
+				continue
+			}
+			var responses kadm.CreatePartitionsResponses
+			responses, err = admin.UpdatePartitions(ctx, int(topic.partitions), topic.name)
+			if err == nil {
+				err = responses.Error()
+			}
+		default:
+			slog.Warn("kafka topic differs from declaration", slog.Group("data",
+				slog.String("topic", diff.Topic),
+				slog.String("type", diff.Type),
+				slog.String("detail", diff.Detail),
+			))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("kafka topic reconciled", slog.Group("data",
+			slog.String("topic", diff.Topic),
+			slog.String("type", diff.Type),
+			slog.String("detail", diff.Detail),
+		))
+	}
+	return diffs, nil
+}
+
+// ConnectKafkaAdmin creates an admin client from the kafka.* configuration
+// without joining the consumer group, for use by CLI commands. It returns a
+// cleanup function that closes the underlying client.
+func ConnectKafkaAdmin(ctx context.Context) (*kadm.Client, func(), error) {
+	options, err := viperClientOptions()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := kgo.NewClient(append(options,
+		kgo.SeedBrokers(config.ViperGet[[]string]("kafka.brokers")...),
+		kgo.ClientID(config.ViperGet[string]("kafka.client-id")),
+	)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = client.Ping(ctx); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return kadm.NewClient(client), client.Close, nil
+}
+
+func declaredTopics() []*Topic {
+	topicMutex.Lock()
+	defer topicMutex.Unlock()
+	topics := make([]*Topic, 0, len(topicRegistry))
+	for _, topic := range topicRegistry {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].name < topics[j].name
+	})
+	return topics
+}
+
+func declaredTopic(name string) *Topic {
+	topicMutex.Lock()
+	defer topicMutex.Unlock()
+	return topicRegistry[name]
+}
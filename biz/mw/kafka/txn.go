@@ -0,0 +1,236 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// txn.go
+//
+// Description: exactly-once produce and consume-transform-produce. When
+// kafka.transactional-id is set, the Service produces in transactions. With a
+// consumer group, ReadLoop runs every poll in one transaction through a
+// kgo.GroupTransactSession: the records produced by the handlers and the
+// offsets of the polled records are committed together, or the transaction is
+// aborted and the records are consumed again.
+
+var (
+	// ErrNotTransactional is returned by Transact when kafka.transactional-id is not set.
+	ErrNotTransactional = errors.New("kafka: service is not transactional")
+	// ErrTransactOutsideHandler is returned by Transact when the Service consumes
+	// in a group transaction and ctx does not come from a consumer handler.
+	ErrTransactOutsideHandler = errors.New("kafka: transact outside of a consumer handler")
+	// ErrTransactionEnded is returned by Tx when its transaction already ended.
+	ErrTransactionEnded = errors.New("kafka: transaction ended")
+)
+
+type txContextKey struct{}
+
+// txCycle is the transaction of one poll of ReadLoop. It is done when every
+// dispatched record was handled, or when it is abandoned because workers were
+// removed or the Service is stopping.
+type txCycle struct {
+	pending atomic.Int64
+	failed  atomic.Bool
+	done    chan struct{}
+	once    sync.Once
+	// mutex keeps records from being produced while the transaction ends.
+	mutex sync.RWMutex
+	ended bool
+}
+
+func newTxCycle() *txCycle {
+	cycle := &txCycle{done: make(chan struct{})}
+	// the guard is released once every record was dispatched.
+	cycle.pending.Store(1)
+	return cycle
+}
+
+// finish marks n records as handled.
+func (self *txCycle) finish(n int) {
+	if self.pending.Add(-int64(n)) == 0 {
+		self.once.Do(func() { close(self.done) })
+	}
+}
+
+// abandon stops waiting for the records of the cycle and aborts the transaction.
+func (self *txCycle) abandon() {
+	self.failed.Store(true)
+	self.once.Do(func() { close(self.done) })
+}
+
+// Tx produces records in a transaction started by Transact.
+type Tx struct {
+	service *Service
+	// cycle is nil for transactions that do not consume.
+	cycle *txCycle
+}
+
+// Produce synchronously produces a message in the transaction. The value is
+// encoded like ProducerWithSync does.
+func (self *Tx) Produce(ctx context.Context, topic string, key []byte, value any, headers ...kgo.RecordHeader) error {
+	body, err := self.service.valueProtocol(value)
+	if err != nil {
+		return err
+	}
+	return self.ProduceRecords(ctx, &kgo.Record{
+		Topic:   topic,
+		Key:     key,
+		Value:   body,
+		Headers: self.service.injectTraceID(ctx, headers),
+	})
+}
+
+// ProduceRecords synchronously produces records in the transaction.
+func (self *Tx) ProduceRecords(ctx context.Context, records ...*kgo.Record) error {
+	if self.cycle != nil {
+		self.cycle.mutex.RLock()
+		defer self.cycle.mutex.RUnlock()
+		if self.cycle.ended || self.cycle.failed.Load() {
+			return ErrTransactionEnded
+		}
+	}
+	return self.service.client.ProduceSync(ctx, records...).FirstErr()
+}
+
+// Transact runs fn in a transaction and commits it when fn returns nil, or aborts it otherwise.
+//
+// Called from a consumer handler of a Service consuming in a group, fn joins
+// the transaction of the current poll: its records are committed together
+// with the offsets of the polled records once every record of the poll was
+// handled. An error returned by fn aborts the whole poll, so its records are
+// consumed again.
+//
+// Without a consumer group, Transact runs a transaction of its own; transactions
+// are serialized. With a consumer group, it must be called with the context of a
+// handler, otherwise it returns ErrTransactOutsideHandler.
+//
+// Example:
+//
+//	err := kafka.Instance().Transact(ctx, func(tx *kafka.Tx) error {
+//		return tx.Produce(ctx, "orders-enriched", record.Key, enriched)
+//	})
+func (self *Service) Transact(ctx context.Context, fn func(tx *Tx) error) error {
+	if tx, ok := ctx.Value(txContextKey{}).(*Tx); ok && tx.service == self {
+		if err := fn(tx); err != nil {
+			if tx.cycle != nil {
+				tx.cycle.failed.Store(true)
+			}
+			return err
+		}
+		return nil
+	}
+	if !self.transactional {
+		return ErrNotTransactional
+	}
+	if self.session != nil {
+		return ErrTransactOutsideHandler
+	}
+	self.txMutex.Lock()
+	defer self.txMutex.Unlock()
+	if err := self.client.BeginTransaction(); err != nil {
+		return err
+	}
+	if err := fn(&Tx{service: self}); err != nil {
+		if abortErr := self.endTransaction(ctx, kgo.TryAbort); abortErr != nil {
+			slog.ErrorContext(ctx, "kafka abort transaction failed", slog.String("name", self.name),
+				slog.String("error", abortErr.Error()))
+		}
+		return err
+	}
+	return self.endTransaction(ctx, kgo.TryCommit)
+}
+
+// produceSync synchronously produces records. A transactional Service produces
+// them through Transact: in the transaction of the handler of ctx, or without a
+// consumer group in a transaction of their own.
+func (self *Service) produceSync(ctx context.Context, records ...*kgo.Record) error {
+	if !self.transactional {
+		return self.client.ProduceSync(ctx, records...).FirstErr()
+	}
+	return self.Transact(ctx, func(tx *Tx) error {
+		return tx.ProduceRecords(ctx, records...)
+	})
+}
+
+// endTransaction flushes or aborts the buffered records and ends the transaction.
+// A transaction whose records cannot be flushed is aborted.
+func (self *Service) endTransaction(ctx context.Context, commit kgo.TransactionEndTry) error {
+	if commit == kgo.TryAbort {
+		if err := self.client.AbortBufferedRecords(ctx); err != nil {
+			return err
+		}
+		return self.client.EndTransaction(ctx, kgo.TryAbort)
+	}
+	if err := self.client.Flush(ctx); err != nil {
+		if abortErr := self.endTransaction(ctx, kgo.TryAbort); abortErr != nil {
+			slog.ErrorContext(ctx, "kafka abort transaction failed", slog.String("name", self.name),
+				slog.String("error", abortErr.Error()))
+		}
+		return err
+	}
+	return self.client.EndTransaction(ctx, kgo.TryCommit)
+}
+
+// transactFetches handles the records of one poll in a group transaction and
+// waits for them before ending the transaction.
+func (self *Service) transactFetches(fetches kgo.Fetches) {
+	if fetches.NumRecords() == 0 {
+		return
+	}
+	if err := self.session.Begin(); err != nil {
+		slog.Error("kafka begin transaction failed", slog.String("name", self.name), slog.String("error", err.Error()))
+		return
+	}
+	cycle := newTxCycle()
+	self.cycle.Store(cycle)
+	iter := fetches.RecordIter()
+	for !iter.Done() {
+		cycle.pending.Add(1)
+		if !self.dispatch(iter.Next()) {
+			cycle.finish(1)
+		}
+	}
+	cycle.finish(1)
+	select {
+	case <-cycle.done:
+	case <-self.ctx.Done():
+		cycle.abandon()
+	}
+	cycle.mutex.Lock()
+	cycle.ended = true
+	cycle.mutex.Unlock()
+	self.cycle.Store(nil)
+
+	commit := kgo.TryCommit
+	if cycle.failed.Load() {
+		commit = kgo.TryAbort
+	}
+	// ending runs even when the Service is stopping, so the transaction does not stay open.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(self.ctx), 10*time.Second)
+	defer cancel()
+	committed, err := self.session.End(ctx, commit)
+	if err != nil {
+		slog.Error("kafka end transaction failed", slog.String("name", self.name), slog.String("error", err.Error()))
+		return
+	}
+	if !committed {
+		slog.Warn("kafka transaction aborted, records will be consumed again", slog.String("name", self.name),
+			slog.Int("records", fetches.NumRecords()))
+	}
+}
+
+// withTx binds the handlers of the current group transaction to it.
+func (self *Service) withTx(ctx context.Context) (context.Context, *txCycle) {
+	cycle := self.cycle.Load()
+	if cycle == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, txContextKey{}, &Tx{service: self, cycle: cycle}), cycle
+}
@@ -0,0 +1,168 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrServiceNotInitialized is returned by Publish and PublishAsync before InitKafkaService has run.
+var ErrServiceNotInitialized = errors.New("kafka: service is not initialized")
+
+// RecordMeta describes the record a typed value was decoded from.
+type RecordMeta struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Headers   []kgo.RecordHeader
+	Timestamp time.Time
+	Record    *kgo.Record
+}
+
+// TypedHandler processes one decoded record value. Returned errors are handled
+// according to the consumer's RetryPolicy.
+type TypedHandler[T any] func(ctx context.Context, value T, meta RecordMeta) error
+
+// DecodeErrorHook is called for records whose value cannot be decoded. Returning nil
+// skips the record, while returning an error fails it like a handler error, which
+// routes it to the dead-letter topic of the consumer's RetryPolicy.
+type DecodeErrorHook func(ctx context.Context, record *kgo.Record, err error) error
+
+// typedSettings holds the behavior of a typed consumer or publish call.
+type typedSettings struct {
+	codec       Codec
+	decodeError DecodeErrorHook
+	headers     []kgo.RecordHeader
+}
+
+// TypedOption customizes a typed consumer or publish call.
+type TypedOption func(opts *typedSettings)
+
+// WithCodec encodes values with codec instead of JSONCodec.
+func WithCodec(codec Codec) TypedOption {
+	return func(opts *typedSettings) {
+		opts.codec = codec
+	}
+}
+
+// WithDecodeErrorHook handles decode failures with hook instead of logging and skipping the record.
+func WithDecodeErrorHook(hook DecodeErrorHook) TypedOption {
+	return func(opts *typedSettings) {
+		opts.decodeError = hook
+	}
+}
+
+// WithHeaders adds headers to published records.
+func WithHeaders(headers ...kgo.RecordHeader) TypedOption {
+	return func(opts *typedSettings) {
+		opts.headers = append(opts.headers, headers...)
+	}
+}
+
+func newTypedSettings(opts []TypedOption) *typedSettings {
+	result := &typedSettings{
+		codec:       JSONCodec,
+		decodeError: logDecodeError,
+	}
+	for _, opt := range opts {
+		opt(result)
+	}
+	return result
+}
+
+// logDecodeError is the default DecodeErrorHook, which logs and skips the record.
+func logDecodeError(ctx context.Context, record *kgo.Record, err error) error {
+	slog.ErrorContext(ctx, "kafka record decode failed, record skipped", slog.Group("data",
+		slog.String("topic", record.Topic),
+		slog.Int("partition", int(record.Partition)),
+		slog.Int64("offset", record.Offset),
+	), slog.String("error", err.Error()))
+	return nil
+}
+
+// NewTypedConsumer returns a consumer that decodes every record value into a T and
+// passes it to handler. Batch consumers decode each record of the batch and report
+// failures per record.
+//
+// Example:
+//
+//	consumer := kafka.NewTypedConsumer(event.OrderTopic,
+//		func(ctx context.Context, order event.OrderCreated, meta kafka.RecordMeta) error {
+//			return service.HandleOrder(ctx, order)
+//		}, kafka.WithCodec(kafka.MsgpackCodec))
+//	kafka.Instance().RegisterConsumers(consumer)
+func NewTypedConsumer[T any](topic string, handler TypedHandler[T], opts ...TypedOption) Consumer {
+	settings := newTypedSettings(opts)
+	return NewConsumer(topic, false, 0, func(ctx context.Context, _ *kgo.Client, _ bool, records ...*kgo.Record) error {
+		var failed RecordErrors
+		for _, record := range records {
+			var value T
+			if err := settings.codec.Unmarshal(record.Value, &value); err != nil {
+				if err = settings.decodeError(ctx, record, err); err != nil {
+					failed = append(failed, RecordError{Record: record, Err: err})
+				}
+				continue
+			}
+			if err := handler(ctx, value, recordMeta(record)); err != nil {
+				failed = append(failed, RecordError{Record: record, Err: err})
+			}
+		}
+		if len(failed) > 0 {
+			return failed
+		}
+		return nil
+	})
+}
+
+// Publish encodes value with the configured codec and sends it to topic
+// synchronously on the default Service.
+func Publish[T any](ctx context.Context, topic string, key []byte, value T, opts ...TypedOption) error {
+	service := Instance()
+	if service == nil {
+		return ErrServiceNotInitialized
+	}
+	settings := newTypedSettings(opts)
+	body, err := settings.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return service.ProducerWithSync(ctx, topic, key, body, settings.headers...)
+}
+
+// PublishAsync encodes value with the configured codec and sends it to topic
+// asynchronously on the default Service. The callback is invoked upon completion.
+func PublishAsync[T any](ctx context.Context, topic string, key []byte, value T,
+	callback func(record *kgo.Record, err error), opts ...TypedOption) {
+	service := Instance()
+	if service == nil {
+		if callback != nil {
+			callback(nil, ErrServiceNotInitialized)
+		}
+		return
+	}
+	settings := newTypedSettings(opts)
+	body, err := settings.codec.Marshal(value)
+	if err != nil {
+		if callback != nil {
+			callback(nil, err)
+		}
+		return
+	}
+	service.ProducerWithAsync(ctx, topic, key, body, callback, settings.headers...)
+}
+
+func recordMeta(record *kgo.Record) RecordMeta {
+	return RecordMeta{
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Headers:   record.Headers,
+		Timestamp: record.Timestamp,
+		Record:    record,
+	}
+}
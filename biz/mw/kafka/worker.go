@@ -2,88 +2,262 @@ package kafka
 
 import (
 	"context"
+	"hash/fnv"
 	"log/slog"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/wnnce/fserv-template/pkg/tool"
 )
 
-// workerCtx holds the context, cancel function, topic, and channel for a topic worker.
+// workerCtx holds the context, cancel function, topic, and channel for a worker. Workers
+// of partition concurrency consumers are bound to a partition, other workers have partition -1.
 type workerCtx struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-	topic  string
-	ticker *time.Ticker
-	ch     chan *kgo.Record
-	mutex  *sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	topic     string
+	partition int32
+	ticker    *time.Ticker
+	ch        chan *kgo.Record
+	mutex     *sync.Mutex
+	// pending counts the records received from ch whose handling has not finished.
+	pending atomic.Int64
+	// done is closed when the worker goroutine exits.
+	done chan struct{}
 }
 
-// ReadLoop starts the main loop for polling and dispatching Kafka messages to topic workers.
-// Each topic is handled by a separate goroutine for concurrent processing.
+// ReadLoop starts the main loop for polling and dispatching Kafka messages to workers and
+// blocks until the service stops. Each consumer spreads its records over one or more
+// goroutines according to its ConcurrencyMode. Use Start to run it in the background.
 func (self *Service) ReadLoop() {
-	if self.running.Load() {
-		return
+	if self.acquireLoop() {
+		self.readLoop()
+	}
+}
+
+// Start runs ReadLoop in a new goroutine. The loop is registered before the goroutine
+// starts, so a Shutdown that follows always waits for it.
+func (self *Service) Start() {
+	if self.acquireLoop() {
+		go self.readLoop()
 	}
-	self.running.Store(true)
-	defer self.running.Store(false)
+}
+
+// acquireLoop marks the read loop as running and registers it with the drain. It
+// reports false when the loop is already running.
+func (self *Service) acquireLoop() bool {
+	if !self.running.CompareAndSwap(false, true) {
+		return false
+	}
+	self.loop.Add(1)
+	return true
+}
+
+func (self *Service) readLoop() {
+	defer func() {
+		self.running.Store(false)
+		self.loop.Done()
+	}()
 	for {
-		if self.ctx.Err() != nil {
-			slog.Info("kafka service context is canceled, service exit")
+		if self.fetchCtx.Err() != nil {
+			slog.Info("kafka service fetching stopped, service exit")
 			return
 		}
 		if len(self.consumers) == 0 {
 			slog.Error("kafka service exit, consumer topics is empty", slog.String("name", self.name))
 			return
 		}
-		fetches := self.client.PollFetches(self.ctx)
+		var fetches kgo.Fetches
+		if self.session != nil {
+			fetches = self.session.PollFetches(self.fetchCtx)
+		} else {
+			fetches = self.client.PollFetches(self.fetchCtx)
+		}
 		if fetches.IsClientClosed() {
 			slog.Error("kafka service run exit, client is closed")
 			return
 		}
+		if self.fetchCtx.Err() != nil {
+			continue
+		}
 		if errs := fetches.Errors(); len(errs) > 0 {
 			for _, err := range errs {
 				slog.Error("kafka service poll fetches error", slog.Any("error", err))
 			}
 			continue
 		}
+		if self.session != nil {
+			self.transactFetches(fetches)
+			continue
+		}
 		iter := fetches.RecordIter()
-		for !iter.Done() {
-			record := iter.Next()
-			self.workerMutex.Lock()
-			work, ok := self.workerMap[record.Topic]
-			if !ok {
-				ctx, cancel := context.WithCancel(self.ctx)
-				work = &workerCtx{
-					ctx:    ctx,
-					cancel: cancel,
-					topic:  record.Topic,
-					ch:     make(chan *kgo.Record, 256),
-					ticker: time.NewTicker(time.Second),
-					mutex:  &sync.Mutex{},
-				}
-				self.workerMap[record.Topic] = work
-				self.workerMutex.Unlock()
-				self.mutex.Lock()
-				consumer, ok := self.consumers[record.Topic]
-				self.mutex.Unlock()
-				if !ok {
-					continue
-				}
-				go self.worker(work, consumer)
+		// records left undispatched when fetching stops are not committed and are fetched again.
+		for !iter.Done() && self.fetchCtx.Err() == nil {
+			if record := iter.Next(); !self.delay(record) {
+				self.dispatch(record)
+			}
+		}
+	}
+}
+
+// dispatch sends a record to the worker chosen by its consumer, starting the worker if needed.
+// It reports whether the record was handed to a worker.
+func (self *Service) dispatch(record *kgo.Record) bool {
+	self.mutex.Lock()
+	consumer, ok := self.consumers[record.Topic]
+	generation := self.generations[record.Topic][record.Partition]
+	self.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	key, partition := workerKey(consumer, record)
+	if partition < 0 {
+		// shared workers outlive revokes, see assigned.
+		parent := record.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		record.Context = context.WithValue(parent, generationKey{}, generation)
+	}
+	self.workerMutex.Lock()
+	work, ok := self.workerMap[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(self.ctx)
+		work = &workerCtx{
+			ctx:       ctx,
+			cancel:    cancel,
+			topic:     record.Topic,
+			partition: partition,
+			ch:        make(chan *kgo.Record, 256),
+			ticker:    time.NewTicker(time.Second),
+			mutex:     &sync.Mutex{},
+			done:      make(chan struct{}),
+		}
+		self.workerMap[key] = work
+		go self.worker(work, consumer)
+	}
+	self.workerMutex.Unlock()
+	if self.managedCommit() {
+		self.tracker.track(record)
+	}
+	sent := true
+	tool.SafeSendWithCallback(work.ctx, work.ch, record, func(err error) {
+		// the worker was removed by a revoke or RemoveConsumers, or the service is stopping.
+		sent = false
+		if self.managedCommit() {
+			self.tracker.untrack(record)
+		}
+	})
+	if sent && self.autoCommit {
+		self.client.MarkCommitRecords(record)
+	}
+	return sent
+}
+
+// workerKey returns the workerMap key of the worker that handles record and the
+// partition the worker is bound to.
+func workerKey(consumer *Consumer, record *kgo.Record) (string, int32) {
+	switch consumer.Concurrency {
+	case PartitionConcurrency:
+		return record.Topic + "/" + strconv.Itoa(int(record.Partition)), record.Partition
+	case KeyConcurrency:
+		var slot uint32
+		if record.Key == nil {
+			slot = uint32(record.Partition)
+		} else {
+			hash := fnv.New32a()
+			_, _ = hash.Write(record.Key)
+			slot = hash.Sum32()
+		}
+		return record.Topic + "#" + strconv.Itoa(int(slot%uint32(consumer.Workers))), -1
+	default:
+		return record.Topic, -1
+	}
+}
+
+// removeWorkers stops and forgets the workers matched by filter.
+func (self *Service) removeWorkers(filter func(work *workerCtx) bool) {
+	self.workerMutex.Lock()
+	defer self.workerMutex.Unlock()
+	for key, work := range self.workerMap {
+		if filter(work) {
+			work.cancel()
+			delete(self.workerMap, key)
+			// the records buffered by the worker are never handled.
+			if cycle := self.cycle.Load(); cycle != nil {
+				cycle.abandon()
 			}
-			tool.SafeSend(work.ctx, work.ch, record)
 		}
 	}
 }
 
+// generationKey is the record context key of the partition generation a record
+// was dispatched in.
+type generationKey struct{}
+
+// revokeGeneration starts a new generation of partitions that were revoked or lost.
+func (self *Service) revokeGeneration(partitions map[string][]int32) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for topic, items := range partitions {
+		generations, ok := self.generations[topic]
+		if !ok {
+			generations = make(map[int32]uint64)
+			self.generations[topic] = generations
+		}
+		for _, partition := range items {
+			generations[partition]++
+		}
+	}
+}
+
+// assigned drops the records a shared worker buffered from partitions that were
+// revoked after the records were dispatched. The new owner of the partition
+// handles them, so handling them here too would only produce duplicates.
+// Partition workers are stopped on revoke instead.
+func (self *Service) assigned(work *workerCtx, records []*kgo.Record) []*kgo.Record {
+	if work.partition >= 0 {
+		return records
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	kept := records[:0]
+	for _, record := range records {
+		var generation uint64
+		if record.Context != nil {
+			generation, _ = record.Context.Value(generationKey{}).(uint64)
+		}
+		if generation == self.generations[record.Topic][record.Partition] {
+			kept = append(kept, record)
+			continue
+		}
+		slog.Info("kafka record of a revoked partition skipped", slog.Group("data",
+			slog.String("topic", record.Topic),
+			slog.Int("partition", int(record.Partition)),
+			slog.Int64("offset", record.Offset),
+		))
+	}
+	return kept
+}
+
+// removePartitionWorkers stops the workers bound to partitions that are no longer assigned.
+func (self *Service) removePartitionWorkers(partitions map[string][]int32) {
+	self.removeWorkers(func(work *workerCtx) bool {
+		return work.partition >= 0 && slices.Contains(partitions[work.topic], work.partition)
+	})
+}
+
 // worker processes messages for a specific topic, supporting batch or single-message handling.
-// It invokes the consumer's Handler for each batch or message.
+// It invokes the consumer's Handler for each batch or message through the consumer's retry policy.
 func (self *Service) worker(ctx *workerCtx, consumer *Consumer) {
 	defer func() {
 		ctx.cancel()
 		ctx.ticker.Stop()
+		close(ctx.done)
 	}()
 	batchRecord := make([]*kgo.Record, 0)
 Loop:
@@ -99,14 +273,15 @@ Loop:
 			if !ok {
 				break Loop
 			}
+			ctx.pending.Add(1)
 			if !consumer.Batch || consumer.BatchMaxCount <= 1 {
-				consumer.Handler(ctx.ctx, self.client, self.autoCommit, record)
+				self.process(ctx, consumer, record)
 				continue
 			}
 			ctx.mutex.Lock()
 			batchRecord = append(batchRecord, record)
 			if len(batchRecord) >= consumer.BatchMaxCount {
-				consumer.Handler(ctx.ctx, self.client, self.autoCommit, batchRecord...)
+				self.process(ctx, consumer, batchRecord...)
 				batchRecord = batchRecord[:0]
 			}
 			ctx.mutex.Unlock()
@@ -116,13 +291,48 @@ Loop:
 			}
 			ctx.mutex.Lock()
 			if len(batchRecord) > 0 {
-				consumer.Handler(ctx.ctx, self.client, self.autoCommit, batchRecord...)
+				self.process(ctx, consumer, batchRecord...)
 				batchRecord = batchRecord[:0]
 			}
 			ctx.mutex.Unlock()
 		}
 	}
 	if len(batchRecord) > 0 {
-		consumer.Handler(ctx.ctx, self.client, self.autoCommit, batchRecord...)
+		self.process(ctx, consumer, batchRecord...)
+	}
+}
+
+// process handles records, updates the topic statistics and, in managed commit mode, marks
+// every record that was handled or escalated as done. Records left unhandled keep the commit
+// point of their partition behind them, so they are consumed again after a restart or rebalance.
+func (self *Service) process(work *workerCtx, consumer *Consumer, records ...*kgo.Record) {
+	count := len(records)
+	records = self.assigned(work, records)
+	ctx, cycle := self.withTx(work.ctx)
+	var unhandled []*kgo.Record
+	if len(records) > 0 {
+		unhandled = self.handle(ctx, consumer, records...)
+	}
+	work.pending.Add(-int64(count))
+	self.topicStats(work.topic).processed.Add(int64(len(records) - len(unhandled)))
+	if cycle != nil {
+		if len(unhandled) > 0 {
+			cycle.failed.Store(true)
+		}
+		cycle.finish(count)
+	}
+	if !self.managedCommit() {
+		return
+	}
+	if len(unhandled) == 0 {
+		self.tracker.markDone(records...)
+		return
+	}
+	done := make([]*kgo.Record, 0, len(records))
+	for _, record := range records {
+		if !slices.Contains(unhandled, record) {
+			done = append(done, record)
+		}
 	}
+	self.tracker.markDone(done...)
 }
@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestWorkerKey(t *testing.T) {
+	topic := NewConsumer("orders", false, 0, nil)
+	partitions := topic.WithPartitionWorkers()
+	keys := topic.WithKeyWorkers(4)
+	tests := []struct {
+		name      string
+		consumer  Consumer
+		record    *kgo.Record
+		key       string
+		partition int32
+	}{
+		{
+			name:      "topic",
+			consumer:  topic,
+			record:    &kgo.Record{Topic: "orders", Partition: 3},
+			key:       "orders",
+			partition: -1,
+		},
+		{
+			name:      "partition",
+			consumer:  partitions,
+			record:    &kgo.Record{Topic: "orders", Partition: 3},
+			key:       "orders/3",
+			partition: 3,
+		},
+		{
+			name:      "key without record key uses the partition",
+			consumer:  keys,
+			record:    &kgo.Record{Topic: "orders", Partition: 6},
+			key:       "orders#2",
+			partition: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, partition := workerKey(&tt.consumer, tt.record)
+			if key != tt.key || partition != tt.partition {
+				t.Errorf("expected %s %d, got %s %d", tt.key, tt.partition, key, partition)
+			}
+		})
+	}
+}
+
+func TestWorkerKey_SameKeySameWorker(t *testing.T) {
+	consumer := NewConsumer("orders", false, 0, nil).WithKeyWorkers(8)
+	first, _ := workerKey(&consumer, &kgo.Record{Topic: "orders", Partition: 0, Key: []byte("user-1")})
+	second, _ := workerKey(&consumer, &kgo.Record{Topic: "orders", Partition: 5, Key: []byte("user-1")})
+	if first != second {
+		t.Errorf("expected the same worker for the same key, got %s and %s", first, second)
+	}
+	seen := make(map[string]struct{})
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		worker, _ := workerKey(&consumer, &kgo.Record{Topic: "orders", Key: []byte(key)})
+		seen[worker] = struct{}{}
+	}
+	if len(seen) < 2 || len(seen) > 8 {
+		t.Errorf("expected keys to spread over at most 8 workers, got %d", len(seen))
+	}
+}
+
+func TestAssigned_SkipsRevokedRecords(t *testing.T) {
+	service := &Service{}
+	service.init(context.Background(), time.Second)
+	consumer := NewConsumer("orders", false, 0, nil)
+	service.consumers["orders"] = &consumer
+	shared := &workerCtx{partition: -1}
+	stale := &kgo.Record{Topic: "orders", Partition: 0, Offset: 1}
+	kept := &kgo.Record{Topic: "orders", Partition: 1, Offset: 1}
+	for _, record := range []*kgo.Record{stale, kept} {
+		// mirrors dispatch for a shared worker.
+		service.mutex.Lock()
+		generation := service.generations[record.Topic][record.Partition]
+		service.mutex.Unlock()
+		record.Context = context.WithValue(context.Background(), generationKey{}, generation)
+	}
+	service.revokeGeneration(map[string][]int32{"orders": {0}})
+	refetched := &kgo.Record{Topic: "orders", Partition: 0, Offset: 1}
+	refetched.Context = context.WithValue(context.Background(), generationKey{}, uint64(1))
+
+	result := service.assigned(shared, []*kgo.Record{stale, kept, refetched})
+	if len(result) != 2 || result[0] != kept || result[1] != refetched {
+		t.Errorf("expected the records of partition 1 and the refetched record, got %v", result)
+	}
+	// partition workers are stopped on revoke and keep every record.
+	if result = service.assigned(&workerCtx{partition: 0}, []*kgo.Record{stale}); len(result) != 1 {
+		t.Errorf("expected a partition worker to keep its records, got %v", result)
+	}
+}
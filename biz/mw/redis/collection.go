@@ -0,0 +1,377 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/dal/db"
+)
+
+// collection.go
+//
+// Description: typed wrappers over sorted sets, lists and sets. Members are
+// encoded with sonic, like the values read by RedisGetStruct, with map keys
+// sorted, so equal values always map to the same member.
+
+// memberAPI is the default sonic configuration with sorted map keys, which
+// makes the encoding of map-typed members deterministic.
+var memberAPI = sonic.Config{SortMapKeys: true}.Froze()
+
+// ScoredMember is a sorted set member together with its score.
+type ScoredMember[T any] struct {
+	Member T       `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// SortedSet is a sorted set of T, typically used for leaderboards.
+//
+// Example:
+//
+//	board := redis.NewSortedSet[string]("leaderboard:weekly")
+//	_, err := board.IncrBy(ctx, userID, 30)
+//	page, err := board.Page(ctx, 1, 20, true)
+type SortedSet[T any] struct {
+	key string
+}
+
+// NewSortedSet returns the sorted set stored under key on the default client.
+func NewSortedSet[T any](key string) *SortedSet[T] {
+	return &SortedSet[T]{key: key}
+}
+
+// Key returns the Redis key of the sorted set.
+func (self *SortedSet[T]) Key() string {
+	return self.key
+}
+
+// Add adds members or updates their scores.
+func (self *SortedSet[T]) Add(ctx context.Context, members ...ScoredMember[T]) error {
+	if len(members) == 0 {
+		return nil
+	}
+	values := make([]redis.Z, 0, len(members))
+	for _, member := range members {
+		encoded, err := encodeMember(member.Member)
+		if err != nil {
+			return err
+		}
+		values = append(values, redis.Z{Score: member.Score, Member: encoded})
+	}
+	return RedisClient().ZAdd(ctx, self.key, values...).Err()
+}
+
+// IncrBy adds delta to the score of member, adding it if needed, and returns the new score.
+func (self *SortedSet[T]) IncrBy(ctx context.Context, member T, delta float64) (float64, error) {
+	encoded, err := encodeMember(member)
+	if err != nil {
+		return 0, err
+	}
+	return RedisClient().ZIncrBy(ctx, self.key, delta, encoded).Result()
+}
+
+// Score returns the score of member and whether it exists.
+func (self *SortedSet[T]) Score(ctx context.Context, member T) (float64, bool, error) {
+	encoded, err := encodeMember(member)
+	if err != nil {
+		return 0, false, err
+	}
+	return notFoundAsFalse(RedisClient().ZScore(ctx, self.key, encoded).Result())
+}
+
+// Rank returns the zero-based rank of member, counted from the highest
+// score when desc is true, and whether it exists.
+func (self *SortedSet[T]) Rank(ctx context.Context, member T, desc bool) (int64, bool, error) {
+	encoded, err := encodeMember(member)
+	if err != nil {
+		return 0, false, err
+	}
+	if desc {
+		return notFoundAsFalse(RedisClient().ZRevRank(ctx, self.key, encoded).Result())
+	}
+	return notFoundAsFalse(RedisClient().ZRank(ctx, self.key, encoded).Result())
+}
+
+// Remove removes members.
+func (self *SortedSet[T]) Remove(ctx context.Context, members ...T) error {
+	encoded, err := encodeMembers(members)
+	if err != nil || len(encoded) == 0 {
+		return err
+	}
+	return RedisClient().ZRem(ctx, self.key, encoded...).Err()
+}
+
+// Len returns the number of members.
+func (self *SortedSet[T]) Len(ctx context.Context) (int64, error) {
+	return RedisClient().ZCard(ctx, self.key).Result()
+}
+
+// Range returns the members ranked start to stop inclusive, counted from the
+// highest score when desc is true. Negative indexes count from the end.
+func (self *SortedSet[T]) Range(ctx context.Context, start, stop int64, desc bool) ([]ScoredMember[T], error) {
+	values, err := RedisClient().ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+		Key:   self.key,
+		Start: start,
+		Stop:  stop,
+		Rev:   desc,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeScored[T](values)
+}
+
+// RangeByScore returns at most count members whose score lies between lower
+// and upper inclusive, skipping offset members. Use math.Inf for open bounds
+// and a negative count for no limit.
+func (self *SortedSet[T]) RangeByScore(ctx context.Context, lower, upper float64, offset, count int64, desc bool) ([]ScoredMember[T], error) {
+	args := redis.ZRangeArgs{
+		Key:     self.key,
+		Start:   formatScore(lower),
+		Stop:    formatScore(upper),
+		ByScore: true,
+		Rev:     desc,
+		Offset:  offset,
+		Count:   count,
+	}
+	if desc {
+		// ZRANGE BYSCORE REV expects the bounds from high to low.
+		args.Start, args.Stop = args.Stop, args.Start
+	}
+	values, err := RedisClient().ZRangeArgsWithScores(ctx, args).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeScored[T](values)
+}
+
+// Page returns one page of members ordered by score, in the same shape as
+// the database page queries.
+func (self *SortedSet[T]) Page(ctx context.Context, page, size int, desc bool) (*db.PageData[ScoredMember[T]], error) {
+	total, err := self.Len(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if total == 0 || size <= 0 {
+		return &db.PageData[ScoredMember[T]]{
+			Current: page,
+			Size:    size,
+			Total:   total,
+			Pages:   0,
+			Records: make([]*ScoredMember[T], 0),
+		}, nil
+	}
+	offset := db.ComputeOffset(total, page, size, false)
+	members, err := self.Range(ctx, offset, offset+int64(size)-1, desc)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*ScoredMember[T], 0, len(members))
+	for i := range members {
+		records = append(records, &members[i])
+	}
+	return &db.PageData[ScoredMember[T]]{
+		Current: page,
+		Size:    size,
+		Total:   total,
+		Pages:   int(math.Ceil(float64(total) / float64(size))),
+		Records: records,
+	}, nil
+}
+
+// List is a list of T that doubles as a FIFO queue: Push appends to the
+// tail and Pop and BlockingPop take from the head.
+type List[T any] struct {
+	key string
+}
+
+// NewList returns the list stored under key on the default client.
+func NewList[T any](key string) *List[T] {
+	return &List[T]{key: key}
+}
+
+// Key returns the Redis key of the list.
+func (self *List[T]) Key() string {
+	return self.key
+}
+
+// Push appends values to the tail of the list.
+func (self *List[T]) Push(ctx context.Context, values ...T) error {
+	encoded, err := encodeMembers(values)
+	if err != nil || len(encoded) == 0 {
+		return err
+	}
+	return RedisClient().RPush(ctx, self.key, encoded...).Err()
+}
+
+// PushFront prepends values to the head of the list.
+func (self *List[T]) PushFront(ctx context.Context, values ...T) error {
+	encoded, err := encodeMembers(values)
+	if err != nil || len(encoded) == 0 {
+		return err
+	}
+	return RedisClient().LPush(ctx, self.key, encoded...).Err()
+}
+
+// Pop removes and returns the head of the list. It returns redis.Nil if the list is empty.
+func (self *List[T]) Pop(ctx context.Context) (T, error) {
+	var result T
+	value, err := RedisClient().LPop(ctx, self.key).Result()
+	if err != nil {
+		return result, err
+	}
+	err = sonic.UnmarshalString(value, &result)
+	return result, err
+}
+
+// BlockingPop waits up to timeout for an element and removes it from the
+// head of the list. A zero timeout waits until ctx is done. It returns
+// redis.Nil on timeout.
+func (self *List[T]) BlockingPop(ctx context.Context, timeout time.Duration) (T, error) {
+	var result T
+	values, err := RedisClient().BLPop(ctx, timeout, self.key).Result()
+	if err != nil {
+		return result, err
+	}
+	// BLPOP returns the key followed by the value.
+	err = sonic.UnmarshalString(values[1], &result)
+	return result, err
+}
+
+// Range returns the elements from start to stop inclusive. Negative indexes count from the end.
+func (self *List[T]) Range(ctx context.Context, start, stop int64) ([]T, error) {
+	values, err := RedisClient().LRange(ctx, self.key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembers[T](values)
+}
+
+// Trim keeps only the elements from start to stop inclusive.
+func (self *List[T]) Trim(ctx context.Context, start, stop int64) error {
+	return RedisClient().LTrim(ctx, self.key, start, stop).Err()
+}
+
+// Len returns the number of elements.
+func (self *List[T]) Len(ctx context.Context) (int64, error) {
+	return RedisClient().LLen(ctx, self.key).Result()
+}
+
+// Set is an unordered set of T.
+type Set[T any] struct {
+	key string
+}
+
+// NewSet returns the set stored under key on the default client.
+func NewSet[T any](key string) *Set[T] {
+	return &Set[T]{key: key}
+}
+
+// Key returns the Redis key of the set.
+func (self *Set[T]) Key() string {
+	return self.key
+}
+
+// Add adds members and returns how many were not already present.
+func (self *Set[T]) Add(ctx context.Context, members ...T) (int64, error) {
+	encoded, err := encodeMembers(members)
+	if err != nil || len(encoded) == 0 {
+		return 0, err
+	}
+	return RedisClient().SAdd(ctx, self.key, encoded...).Result()
+}
+
+// Remove removes members.
+func (self *Set[T]) Remove(ctx context.Context, members ...T) error {
+	encoded, err := encodeMembers(members)
+	if err != nil || len(encoded) == 0 {
+		return err
+	}
+	return RedisClient().SRem(ctx, self.key, encoded...).Err()
+}
+
+// Contains reports whether member is in the set.
+func (self *Set[T]) Contains(ctx context.Context, member T) (bool, error) {
+	encoded, err := encodeMember(member)
+	if err != nil {
+		return false, err
+	}
+	return RedisClient().SIsMember(ctx, self.key, encoded).Result()
+}
+
+// Members returns every member of the set.
+func (self *Set[T]) Members(ctx context.Context) ([]T, error) {
+	values, err := RedisClient().SMembers(ctx, self.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembers[T](values)
+}
+
+// Len returns the number of members.
+func (self *Set[T]) Len(ctx context.Context) (int64, error) {
+	return RedisClient().SCard(ctx, self.key).Result()
+}
+
+func encodeMember(member any) (string, error) {
+	data, err := memberAPI.Marshal(member)
+	return string(data), err
+}
+
+func encodeMembers[T any](members []T) ([]any, error) {
+	result := make([]any, 0, len(members))
+	for _, member := range members {
+		encoded, err := encodeMember(member)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, encoded)
+	}
+	return result, nil
+}
+
+func decodeMembers[T any](values []string) ([]T, error) {
+	result := make([]T, len(values))
+	for i, value := range values {
+		if err := sonic.UnmarshalString(value, &result[i]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func decodeScored[T any](values []redis.Z) ([]ScoredMember[T], error) {
+	result := make([]ScoredMember[T], len(values))
+	for i, value := range values {
+		member, _ := value.Member.(string)
+		if err := sonic.UnmarshalString(member, &result[i].Member); err != nil {
+			return nil, err
+		}
+		result[i].Score = value.Score
+	}
+	return result, nil
+}
+
+// formatScore renders a score bound, mapping infinities to -inf and +inf.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, -1):
+		return "-inf"
+	case math.IsInf(score, 1):
+		return "+inf"
+	default:
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+}
+
+// notFoundAsFalse turns a redis.Nil result into a false existence flag.
+func notFoundAsFalse[V any](value V, err error) (V, bool, error) {
+	if errors.Is(err, redis.Nil) {
+		return value, false, nil
+	}
+	return value, err == nil, err
+}
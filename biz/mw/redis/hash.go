@@ -0,0 +1,264 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/redis/go-redis/v9"
+)
+
+// hash.go
+//
+// Description: maps structs to Redis hashes. Every exported field becomes a
+// hash field named by its `redis` tag, or by the field name when untagged;
+// `redis:"-"` skips a field and `redis:",omitempty"` skips zero values.
+// Strings, booleans and numbers are stored as plain text so that they stay
+// readable and work with HINCRBY; every other type is encoded with sonic.
+
+// hashField describes how one struct field maps to a hash field.
+type hashField struct {
+	index     []int
+	name      string
+	omitEmpty bool
+}
+
+var hashFieldCache sync.Map
+
+// Hash is a struct of type T stored as a Redis hash under a single key.
+//
+// Example:
+//
+//	type Profile struct {
+//		Name   string   `redis:"name"`
+//		Points int64    `redis:"points"`
+//		Tags   []string `redis:"tags"`
+//	}
+//
+//	profiles := redis.NewHash[Profile]("profile:" + userID)
+//	err := profiles.Save(ctx, profile, time.Hour)
+//	points, err := profiles.IncrBy(ctx, "points", 10)
+type Hash[T any] struct {
+	key string
+}
+
+// NewHash returns the hash stored under key on the default client.
+func NewHash[T any](key string) *Hash[T] {
+	return &Hash[T]{key: key}
+}
+
+// Key returns the Redis key of the hash.
+func (self *Hash[T]) Key() string {
+	return self.key
+}
+
+// Save writes every field of value. A positive ttl also sets the expiration.
+func (self *Hash[T]) Save(ctx context.Context, value T, ttl time.Duration) error {
+	values, err := structToHash(value)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	_, err = RedisClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, self.key, values...)
+		if ttl > 0 {
+			pipe.Expire(ctx, self.key, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Load reads the hash into a T. It returns redis.Nil if the key does not exist.
+func (self *Hash[T]) Load(ctx context.Context) (T, error) {
+	var result T
+	values, err := RedisClient().HGetAll(ctx, self.key).Result()
+	if err != nil {
+		return result, err
+	}
+	if len(values) == 0 {
+		return result, redis.Nil
+	}
+	err = hashToStruct(values, &result)
+	return result, err
+}
+
+// SetField writes a single field, encoding value the same way as Save.
+func (self *Hash[T]) SetField(ctx context.Context, field string, value any) error {
+	encoded, err := encodeHashValue(reflect.ValueOf(value))
+	if err != nil {
+		return err
+	}
+	return RedisClient().HSet(ctx, self.key, field, encoded).Err()
+}
+
+// IncrBy atomically adds delta to an integer field and returns the new value.
+func (self *Hash[T]) IncrBy(ctx context.Context, field string, delta int64) (int64, error) {
+	return RedisClient().HIncrBy(ctx, self.key, field, delta).Result()
+}
+
+// DeleteFields removes the given fields.
+func (self *Hash[T]) DeleteFields(ctx context.Context, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return RedisClient().HDel(ctx, self.key, fields...).Err()
+}
+
+// Delete removes the whole hash.
+func (self *Hash[T]) Delete(ctx context.Context) error {
+	return RedisClient().Del(ctx, self.key).Err()
+}
+
+// hashFields returns the cached field mapping of a struct type.
+func hashFields(typ reflect.Type) []hashField {
+	if cached, ok := hashFieldCache.Load(typ); ok {
+		return cached.([]hashField)
+	}
+	fields := make([]hashField, 0, typ.NumField())
+	for _, structField := range reflect.VisibleFields(typ) {
+		if !structField.IsExported() || structField.Anonymous {
+			continue
+		}
+		name, options, _ := strings.Cut(structField.Tag.Get("redis"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+		fields = append(fields, hashField{
+			index:     structField.Index,
+			name:      name,
+			omitEmpty: options == "omitempty",
+		})
+	}
+	hashFieldCache.Store(typ, fields)
+	return fields
+}
+
+func structToHash(value any) ([]any, error) {
+	rv := reflect.Indirect(reflect.ValueOf(value))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("redis hash value must be a struct, got %s", rv.Kind())
+	}
+	fields := hashFields(rv.Type())
+	result := make([]any, 0, len(fields)*2)
+	for _, field := range fields {
+		fieldValue, err := rv.FieldByIndexErr(field.index)
+		if err != nil || (field.omitEmpty && fieldValue.IsZero()) {
+			continue
+		}
+		encoded, err := encodeHashValue(fieldValue)
+		if err != nil {
+			return nil, fmt.Errorf("redis hash field %s: %w", field.name, err)
+		}
+		result = append(result, field.name, encoded)
+	}
+	return result, nil
+}
+
+func hashToStruct(values map[string]string, dst any) error {
+	rv := reflect.ValueOf(dst).Elem()
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("redis hash value must be a struct, got %s", rv.Kind())
+	}
+	for _, field := range hashFields(rv.Type()) {
+		raw, ok := values[field.name]
+		if !ok {
+			continue
+		}
+		fieldValue, err := rv.FieldByIndexErr(field.index)
+		if err != nil {
+			// a nil embedded pointer on the path, allocate it and retry.
+			fieldValue = fieldByIndexAlloc(rv, field.index)
+		}
+		if err = decodeHashValue(raw, fieldValue); err != nil {
+			return fmt.Errorf("redis hash field %s: %w", field.name, err)
+		}
+	}
+	return nil
+}
+
+func fieldByIndexAlloc(rv reflect.Value, index []int) reflect.Value {
+	for i, position := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(position)
+	}
+	return rv
+}
+
+func encodeHashValue(rv reflect.Value) (string, error) {
+	if !rv.IsValid() {
+		return "", nil
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits()), nil
+	default:
+	}
+	data, err := sonic.Marshal(rv.Interface())
+	return string(data), err
+}
+
+func decodeHashValue(raw string, rv reflect.Value) error {
+	var err error
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(raw)
+		return nil
+	case reflect.Bool:
+		var value bool
+		if value, err = strconv.ParseBool(raw); err == nil {
+			rv.SetBool(value)
+		}
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var value int64
+		if value, err = strconv.ParseInt(raw, 10, rv.Type().Bits()); err == nil {
+			rv.SetInt(value)
+		}
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var value uint64
+		if value, err = strconv.ParseUint(raw, 10, rv.Type().Bits()); err == nil {
+			rv.SetUint(value)
+		}
+		return err
+	case reflect.Float32, reflect.Float64:
+		var value float64
+		if value, err = strconv.ParseFloat(raw, rv.Type().Bits()); err == nil {
+			rv.SetFloat(value)
+		}
+		return err
+	default:
+	}
+	if raw == "" {
+		return nil
+	}
+	if !rv.CanAddr() {
+		return errors.New("field is not addressable")
+	}
+	return sonic.UnmarshalString(raw, rv.Addr().Interface())
+}
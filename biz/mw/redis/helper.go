@@ -13,7 +13,7 @@ import (
 // Description: TODO: Describe this file
 // Created:     2025/7/12 11:56
 
-func RedisGetStruct[T any](ctx context.Context, key string, client *redis.Client) (T, error) {
+func RedisGetStruct[T any](ctx context.Context, key string, client redis.UniversalClient) (T, error) {
 	var empty T
 	result, err := client.Get(ctx, key).Result()
 	if err != nil {
@@ -23,7 +23,7 @@ func RedisGetStruct[T any](ctx context.Context, key string, client *redis.Client
 	return empty, err
 }
 
-func RedisGetAddrStruct[T any](ctx context.Context, key string, client *redis.Client) (*T, error) {
+func RedisGetAddrStruct[T any](ctx context.Context, key string, client redis.UniversalClient) (*T, error) {
 	var empty T
 	result, err := client.Get(ctx, key).Result()
 	if err != nil {
@@ -33,7 +33,7 @@ func RedisGetAddrStruct[T any](ctx context.Context, key string, client *redis.Cl
 	return &empty, err
 }
 
-func RedisGetSlice[T any](ctx context.Context, key string, client *redis.Client) ([]T, error) {
+func RedisGetSlice[T any](ctx context.Context, key string, client redis.UniversalClient) ([]T, error) {
 	result, err := client.Get(ctx, key).Result()
 	if err != nil {
 		return nil, err
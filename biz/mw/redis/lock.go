@@ -0,0 +1,216 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// lock.go
+//
+// Description: distributed locks with fencing tokens and watchdog renewal.
+// The lock key and the fencing counter share a hash tag so that both live in
+// the same cluster slot and can be used from one script.
+
+const (
+	defaultLockTTL   = 30 * time.Second
+	lockRetryBackoff = 50 * time.Millisecond
+)
+
+var (
+	// ErrLockNotAcquired is returned by TryLock when the lock is still held by
+	// another owner after the wait time elapses.
+	ErrLockNotAcquired = errors.New("redis lock not acquired")
+
+	// ErrLockNotHeld is returned by Unlock when the lock expired or was taken
+	// over by another owner.
+	ErrLockNotHeld = errors.New("redis lock not held")
+)
+
+var (
+	// acquireScript sets the lock if it is free and returns a new fencing token, or 0.
+	acquireScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0`)
+
+	// releaseScript deletes the lock only if it still belongs to the caller.
+	releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+	// renewScript extends the lock only if it still belongs to the caller.
+	renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Mutex is a held distributed lock. A watchdog renews it every ttl/3 until
+// Unlock is called; if renewal finds the lock lost, Context is canceled.
+type Mutex struct {
+	client redis.UniversalClient
+	name   string
+	key    string
+	value  string
+	token  int64
+	ttl    time.Duration
+	ctx    context.Context
+	cancel context.CancelFunc
+	// the watchdog keeps renewing after the caller context is done, until Unlock.
+	watchCtx  context.Context
+	stopWatch context.CancelFunc
+	done      chan struct{}
+	once      sync.Once
+}
+
+// Lock blocks until the named lock is acquired or ctx is done.
+func Lock(ctx context.Context, name string, ttl time.Duration) (*Mutex, error) {
+	return acquire(ctx, name, ttl, -1)
+}
+
+// TryLock tries to acquire the named lock for up to wait. A zero wait makes a
+// single attempt. It returns ErrLockNotAcquired if the lock is still held.
+func TryLock(ctx context.Context, name string, ttl, wait time.Duration) (*Mutex, error) {
+	return acquire(ctx, name, ttl, wait)
+}
+
+// WithLock runs fn while holding the named lock, waiting for it as Lock does.
+// The context passed to fn is canceled if the lock is lost or ctx is done, and the fencing
+// token should be passed to the resources fn writes to so they can reject
+// writes from a stale holder.
+//
+// Example:
+//
+//	err := redis.WithLock(ctx, "job:daily-report", func(ctx context.Context, token int64) error {
+//		return reportService.Generate(ctx, token)
+//	})
+func WithLock(ctx context.Context, name string, fn func(ctx context.Context, token int64) error) error {
+	mutex, err := Lock(ctx, name, defaultLockTTL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := mutex.Unlock(context.WithoutCancel(ctx)); err != nil {
+			slog.WarnContext(ctx, "redis lock unlock failed", slog.String("name", name), slog.String("error", err.Error()))
+		}
+	}()
+	return fn(mutex.Context(), mutex.Token())
+}
+
+// acquire retries the acquire script until it succeeds, ctx is done or wait
+// elapses. A negative wait retries until ctx is done.
+func acquire(ctx context.Context, name string, ttl, wait time.Duration) (*Mutex, error) {
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	client := RedisClient()
+	key := "lock:{" + name + "}"
+	value := uuid.NewString()
+	deadline := time.Now().Add(wait)
+	for {
+		token, err := acquireScript.Run(ctx, client, []string{key, key + ":fence"}, value, ttl.Milliseconds()).Int64()
+		if err != nil {
+			return nil, err
+		}
+		if token > 0 {
+			return newMutex(ctx, client, name, key, value, token, ttl), nil
+		}
+		if wait >= 0 && !time.Now().Before(deadline) {
+			return nil, ErrLockNotAcquired
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryBackoff):
+		}
+	}
+}
+
+func newMutex(ctx context.Context, client redis.UniversalClient, name, key, value string, token int64, ttl time.Duration) *Mutex {
+	childCtx, cancel := context.WithCancel(ctx)
+	watchCtx, stopWatch := context.WithCancel(context.WithoutCancel(ctx))
+	mutex := &Mutex{
+		client:    client,
+		name:      name,
+		key:       key,
+		value:     value,
+		token:     token,
+		ttl:       ttl,
+		ctx:       childCtx,
+		cancel:    cancel,
+		watchCtx:  watchCtx,
+		stopWatch: stopWatch,
+		done:      make(chan struct{}),
+	}
+	go mutex.watchdog()
+	return mutex
+}
+
+// watchdog renews the lock until Unlock is called or the lock is lost.
+func (self *Mutex) watchdog() {
+	defer close(self.done)
+	ticker := time.NewTicker(self.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.watchCtx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := renewScript.Run(self.watchCtx, self.client, []string{self.key}, self.value, self.ttl.Milliseconds()).Int64()
+			if err != nil {
+				if self.watchCtx.Err() == nil {
+					slog.Warn("redis lock renew failed", slog.String("name", self.name), slog.String("error", err.Error()))
+				}
+				continue
+			}
+			if renewed == 0 {
+				slog.Error("redis lock lost", slog.String("name", self.name), slog.Int64("token", self.token))
+				self.cancel()
+				return
+			}
+		}
+	}
+}
+
+// Name returns the lock name.
+func (self *Mutex) Name() string {
+	return self.name
+}
+
+// Token returns the fencing token, which increases every time the lock is acquired.
+func (self *Mutex) Token() int64 {
+	return self.token
+}
+
+// Context returns a context that is canceled when the lock is lost or released,
+// or when the context the lock was acquired with is done.
+func (self *Mutex) Context() context.Context {
+	return self.ctx
+}
+
+// Unlock stops the watchdog and releases the lock. It returns ErrLockNotHeld
+// if the lock had already expired or been taken over.
+func (self *Mutex) Unlock(ctx context.Context) error {
+	err := ErrLockNotHeld
+	self.once.Do(func() {
+		self.cancel()
+		self.stopWatch()
+		<-self.done
+		var released int64
+		released, err = releaseScript.Run(ctx, self.client, []string{self.key}, self.value).Int64()
+		if err == nil && released == 0 {
+			err = ErrLockNotHeld
+		}
+	})
+	return err
+}
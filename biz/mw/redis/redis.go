@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -17,34 +18,77 @@ import (
 // Created:     2025/7/12 08:38
 
 var (
-	defaultClient *redis.Client
+	defaultClient redis.UniversalClient
 )
 
-func RedisClient() *redis.Client {
+// Redis deployment modes selected by redis.mode.
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
+)
+
+func RedisClient() redis.UniversalClient {
 	return defaultClient
 }
 
+// InitRedis creates the default client for the deployment selected by
+// redis.mode: a single node at redis.host:redis.port, a Sentinel-managed
+// master named redis.master-name found through redis.sentinel-addrs, or a
+// cluster seeded with redis.cluster-nodes. redis.index is ignored in cluster
+// mode, which only has database 0.
 func InitRedis(ctx context.Context) (func(), error) {
-	host := config.ViperGet[string]("redis.host", "127.0.0.1")
-	port := config.ViperGet[int]("redis.port", 6379)
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:         fmt.Sprintf("%s:%d", host, port),
-		DB:           config.ViperGet[int]("redis.index", 0),
-		Username:     config.ViperGet[string]("redis.username"),
-		Password:     config.ViperGet[string]("redis.password"),
-		ReadTimeout:  config.ViperGet[time.Duration]("redis.timeout", 3*time.Second),
-		WriteTimeout: config.ViperGet[time.Duration]("redis.timeout", 3*time.Second),
-	})
-	_, err := redisClient.Ping(ctx).Result()
+	mode := config.ViperGet[string]("redis.mode", ModeSingle)
+	tlsConfig, err := config.ViperTLSConfig("redis.tls")
 	if err != nil {
+		return nil, err
+	}
+	timeout := config.ViperGet[time.Duration]("redis.timeout", 3*time.Second)
+	opts := &redis.UniversalOptions{
+		DB:               config.ViperGet[int]("redis.index", 0),
+		Username:         config.ViperGet[string]("redis.username"),
+		Password:         config.ViperGet[string]("redis.password"),
+		ReadTimeout:      timeout,
+		WriteTimeout:     timeout,
+		PoolSize:         config.ViperGet[int]("redis.pool-size", 0),
+		MinIdleConns:     config.ViperGet[int]("redis.min-idle-conns", 0),
+		TLSConfig:        tlsConfig,
+		MasterName:       config.ViperGet[string]("redis.master-name"),
+		SentinelUsername: config.ViperGet[string]("redis.sentinel-username"),
+		SentinelPassword: config.ViperGet[string]("redis.sentinel-password"),
+	}
+	var redisClient redis.UniversalClient
+	switch mode {
+	case ModeSingle:
+		host := config.ViperGet[string]("redis.host", "127.0.0.1")
+		port := config.ViperGet[int]("redis.port", 6379)
+		opts.Addrs = []string{fmt.Sprintf("%s:%d", host, port)}
+		redisClient = redis.NewClient(opts.Simple())
+	case ModeSentinel:
+		opts.Addrs = config.ViperGet[[]string]("redis.sentinel-addrs")
+		if opts.MasterName == "" || len(opts.Addrs) == 0 {
+			return nil, errors.New("redis sentinel mode requires redis.master-name and redis.sentinel-addrs")
+		}
+		redisClient = redis.NewFailoverClient(opts.Failover())
+	case ModeCluster:
+		opts.Addrs = config.ViperGet[[]string]("redis.cluster-nodes")
+		if len(opts.Addrs) == 0 {
+			return nil, errors.New("redis cluster mode requires redis.cluster-nodes")
+		}
+		redisClient = redis.NewClusterClient(opts.Cluster())
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", mode)
+	}
+	if _, err = redisClient.Ping(ctx).Result(); err != nil {
 		slog.Error("create redis defaultClient failed", slog.Group("data",
-			slog.String("host", host),
-			slog.Int("port", port),
+			slog.String("mode", mode),
+			slog.Any("addrs", opts.Addrs),
 		), slog.String("error", err.Error()))
+		_ = redisClient.Close()
 		return nil, err
 	}
 	defaultClient = redisClient
 	return func() {
 		_ = defaultClient.Close()
-	}, err
+	}, nil
 }
@@ -0,0 +1,365 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/config"
+	"github.com/wnnce/fserv-template/internal/constat"
+)
+
+// stream.go
+//
+// Description: a Redis Streams consumer service for deployments that need a
+// durable queue without Kafka. Messages are read through a consumer group and
+// acknowledged only after the handler succeeds; messages left pending by a
+// failed handler or a dead consumer are reclaimed with XAUTOCLAIM and moved
+// to a dead-letter stream once they exceed the retry limit.
+
+// StreamHandler processes messages read from a stream. Returning nil
+// acknowledges every message; returning an error leaves them pending so that
+// they are delivered again after the reclaim idle time.
+type StreamHandler func(ctx context.Context, client redis.UniversalClient, messages ...redis.XMessage) error
+
+// StreamConsumer binds a handler to a stream. Count is the maximum number of
+// messages passed to one handler call.
+type StreamConsumer struct {
+	Stream  string
+	Count   int64
+	Handler StreamHandler
+}
+
+func NewStreamConsumer(stream string, count int64, handler StreamHandler) StreamConsumer {
+	return StreamConsumer{
+		Stream:  stream,
+		Count:   count,
+		Handler: handler,
+	}
+}
+
+// StreamService reads the registered streams through one consumer group,
+// running one worker goroutine per stream.
+type StreamService struct {
+	running         atomic.Bool
+	name            string
+	client          redis.UniversalClient
+	group           string
+	consumer        string
+	block           time.Duration
+	minIdle         time.Duration
+	reclaimInterval time.Duration
+	maxRetries      int64
+	deadSuffix      string
+	mutex           *sync.Mutex
+	consumers       map[string]*StreamConsumer
+	workerMap       map[string]context.CancelFunc
+	wg              sync.WaitGroup
+	ctx             context.Context
+	cancel          context.CancelFunc
+	once            sync.Once
+}
+
+var (
+	defaultStreamService *StreamService
+)
+
+// InitStreamService initializes the global StreamService from the
+// "redis.stream" configuration and returns a cleanup function. It must run
+// after InitRedis.
+func InitStreamService(ctx context.Context) (func(), error) {
+	hostname, _ := os.Hostname()
+	childCtx, cancel := context.WithCancel(ctx)
+	defaultStreamService = &StreamService{
+		name:            config.ViperGet[string]("redis.stream.name", "default"),
+		client:          RedisClient(),
+		group:           config.ViperGet[string]("redis.stream.group", config.ViperGet[string]("server.name", "fserv-template")),
+		consumer:        config.ViperGet[string]("redis.stream.consumer", hostname),
+		block:           config.ViperGet[time.Duration]("redis.stream.block", 5*time.Second),
+		minIdle:         config.ViperGet[time.Duration]("redis.stream.min-idle", time.Minute),
+		reclaimInterval: config.ViperGet[time.Duration]("redis.stream.reclaim-interval", 30*time.Second),
+		maxRetries:      config.ViperGet[int64]("redis.stream.max-retries", 5),
+		deadSuffix:      config.ViperGet[string]("redis.stream.dead-letter-suffix", ":dead"),
+		mutex:           &sync.Mutex{},
+		consumers:       make(map[string]*StreamConsumer),
+		workerMap:       make(map[string]context.CancelFunc),
+		ctx:             childCtx,
+		cancel:          cancel,
+	}
+	return func() {
+		defaultStreamService.Shutdown()
+	}, nil
+}
+
+// StreamInstance returns the default global StreamService instance.
+func StreamInstance() *StreamService {
+	return defaultStreamService
+}
+
+// RegisterConsumers creates the consumer group of every stream if needed and
+// registers the consumers. Consumers registered while ReadLoop is running are
+// started immediately.
+func (self *StreamService) RegisterConsumers(consumers ...StreamConsumer) error {
+	if self.ctx.Err() != nil || len(consumers) == 0 {
+		return nil
+	}
+	for _, consumer := range consumers {
+		if strings.TrimSpace(consumer.Stream) == "" || consumer.Handler == nil {
+			continue
+		}
+		if consumer.Count <= 0 {
+			consumer.Count = 1
+		}
+		err := self.client.XGroupCreateMkStream(self.ctx, consumer.Stream, self.group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+		self.mutex.Lock()
+		if _, ok := self.consumers[consumer.Stream]; !ok {
+			self.consumers[consumer.Stream] = &consumer
+			if self.running.Load() {
+				self.startWorker(&consumer)
+			}
+		}
+		self.mutex.Unlock()
+	}
+	return nil
+}
+
+// RemoveConsumers stops and removes the consumers of the given streams.
+// Pending messages remain in the group and are reclaimed by other instances.
+func (self *StreamService) RemoveConsumers(streams ...string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for _, stream := range streams {
+		delete(self.consumers, stream)
+		if cancel, ok := self.workerMap[stream]; ok {
+			cancel()
+			delete(self.workerMap, stream)
+		}
+	}
+}
+
+// Publish appends values to stream, adding the traceId of ctx as a field when
+// present so that handlers can restore it; values itself is left unchanged.
+// maxLen, if positive, trims the stream approximately to that length.
+func (self *StreamService) Publish(ctx context.Context, stream string, values map[string]any, maxLen int64) (string, error) {
+	if traceID, ok := ctx.Value(constat.ContextTraceKey).(string); ok {
+		if _, exists := values[constat.ContextTraceKey]; !exists {
+			copied := make(map[string]any, len(values)+1)
+			for key, value := range values {
+				copied[key] = value
+			}
+			copied[constat.ContextTraceKey] = traceID
+			values = copied
+		}
+	}
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}
+	if maxLen > 0 {
+		args.MaxLen = maxLen
+		args.Approx = true
+	}
+	return self.client.XAdd(ctx, args).Result()
+}
+
+// ReadLoop starts a worker for every registered stream and blocks until the
+// service is shut down.
+func (self *StreamService) ReadLoop() {
+	if self.running.Swap(true) {
+		return
+	}
+	defer self.running.Store(false)
+	self.mutex.Lock()
+	for _, consumer := range self.consumers {
+		self.startWorker(consumer)
+	}
+	self.mutex.Unlock()
+	<-self.ctx.Done()
+	slog.Info("redis stream service context is canceled, service exit", slog.String("name", self.name))
+}
+
+// Shutdown stops every worker and waits for in-flight handlers to return.
+func (self *StreamService) Shutdown() {
+	self.once.Do(func() {
+		self.cancel()
+		self.wg.Wait()
+	})
+}
+
+// startWorker must be called with mutex held.
+func (self *StreamService) startWorker(consumer *StreamConsumer) {
+	if _, ok := self.workerMap[consumer.Stream]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(self.ctx)
+	self.workerMap[consumer.Stream] = cancel
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+		self.worker(ctx, consumer)
+	}()
+}
+
+// worker reads new messages for one stream and periodically reclaims
+// messages that stayed pending longer than minIdle.
+func (self *StreamService) worker(ctx context.Context, consumer *StreamConsumer) {
+	lastReclaim := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastReclaim) >= self.reclaimInterval {
+			self.reclaim(ctx, consumer)
+			lastReclaim = time.Now()
+		}
+		streams, err := self.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    self.group,
+			Consumer: self.consumer,
+			Streams:  []string{consumer.Stream, ">"},
+			Count:    consumer.Count,
+			Block:    self.block,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			slog.Error("redis stream read failed", slog.String("stream", consumer.Stream), slog.String("error", err.Error()))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, stream := range streams {
+			self.handle(ctx, consumer, stream.Messages)
+		}
+	}
+	slog.Info("redis stream worker exit", slog.String("stream", consumer.Stream))
+}
+
+// handle calls the handler and acknowledges the messages if it succeeds.
+func (self *StreamService) handle(ctx context.Context, consumer *StreamConsumer, messages []redis.XMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	if err := consumer.Handler(ctx, self.client, messages...); err != nil {
+		slog.Warn("redis stream handler failed, messages stay pending", slog.Group("data",
+			slog.String("stream", consumer.Stream),
+			slog.String("first", messages[0].ID),
+			slog.Int("count", len(messages)),
+		), slog.String("error", err.Error()))
+		return
+	}
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	if err := self.client.XAck(context.WithoutCancel(ctx), consumer.Stream, self.group, ids...).Err(); err != nil {
+		slog.Error("redis stream ack failed", slog.String("stream", consumer.Stream), slog.String("error", err.Error()))
+	}
+}
+
+// reclaim claims messages idle for longer than minIdle, moves those that
+// exceeded maxRetries to the dead-letter stream and handles the rest again.
+func (self *StreamService) reclaim(ctx context.Context, consumer *StreamConsumer) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := self.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   consumer.Stream,
+			Group:    self.group,
+			Consumer: self.consumer,
+			MinIdle:  self.minIdle,
+			Start:    start,
+			Count:    consumer.Count,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("redis stream reclaim failed", slog.String("stream", consumer.Stream), slog.String("error", err.Error()))
+			}
+			return
+		}
+		if len(messages) > 0 {
+			retries, err := self.retryCounts(ctx, consumer.Stream, messages)
+			if err != nil {
+				slog.Error("redis stream pending lookup failed", slog.String("stream", consumer.Stream), slog.String("error", err.Error()))
+				return
+			}
+			retry := make([]redis.XMessage, 0, len(messages))
+			for _, message := range messages {
+				if retries[message.ID] > self.maxRetries {
+					self.deadLetter(ctx, consumer.Stream, message, retries[message.ID])
+					continue
+				}
+				retry = append(retry, message)
+			}
+			self.handle(ctx, consumer, retry)
+		}
+		if next == "0-0" || next == "" {
+			return
+		}
+		start = next
+	}
+}
+
+// retryCounts returns the delivery count of every claimed message. The pending
+// entries are looked up by ID in one pipeline, since a range query over the
+// claimed IDs may be filled by other pending entries in between.
+func (self *StreamService) retryCounts(ctx context.Context, stream string, messages []redis.XMessage) (map[string]int64, error) {
+	commands := make([]*redis.XPendingExtCmd, 0, len(messages))
+	_, err := self.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, message := range messages {
+			commands = append(commands, pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream:   stream,
+				Group:    self.group,
+				Start:    message.ID,
+				End:      message.ID,
+				Count:    1,
+				Consumer: self.consumer,
+			}))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(messages))
+	for _, command := range commands {
+		for _, entry := range command.Val() {
+			result[entry.ID] = entry.RetryCount
+		}
+	}
+	return result, nil
+}
+
+// deadLetter copies message to the dead-letter stream together with its
+// origin and delivery count, then acknowledges it.
+func (self *StreamService) deadLetter(ctx context.Context, stream string, message redis.XMessage, retries int64) {
+	values := make(map[string]any, len(message.Values)+3)
+	for key, value := range message.Values {
+		values[key] = value
+	}
+	values["x-origin-stream"] = stream
+	values["x-origin-id"] = message.ID
+	values["x-retries"] = strconv.FormatInt(retries, 10)
+	ctx = context.WithoutCancel(ctx)
+	if err := self.client.XAdd(ctx, &redis.XAddArgs{Stream: stream + self.deadSuffix, Values: values}).Err(); err != nil {
+		slog.Error("redis stream dead letter failed", slog.String("stream", stream), slog.String("id", message.ID), slog.String("error", err.Error()))
+		return
+	}
+	if err := self.client.XAck(ctx, stream, self.group, message.ID).Err(); err != nil {
+		slog.Error("redis stream ack failed", slog.String("stream", stream), slog.String("error", err.Error()))
+		return
+	}
+	slog.Warn("redis stream message moved to dead letter", slog.Group("data",
+		slog.String("stream", stream),
+		slog.String("id", message.ID),
+		slog.Int64("retries", retries),
+	))
+}
@@ -0,0 +1,82 @@
+package route
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wnnce/fserv-template/biz/handler"
+	"github.com/wnnce/fserv-template/biz/mw/kafka"
+	"github.com/wnnce/fserv-template/config"
+)
+
+// kafka.go
+//
+// Description: admin endpoints to inspect and control the consumers of the
+// default Kafka service at runtime, e.g. to pause a misbehaving consumer
+// during an incident without redeploying.
+//
+//	GET  /admin/kafka/consumers                list the status of every consumer
+//	GET  /admin/kafka/consumers/:topic         show the status of one consumer
+//	POST /admin/kafka/consumers/:topic/pause   stop fetching the topic
+//	POST /admin/kafka/consumers/:topic/resume  resume fetching the topic
+
+// AdminTokenHeader carries the token configured by kafka.admin.token.
+const AdminTokenHeader = "X-Admin-Token"
+
+// RegisterKafkaAdmin registers the Kafka admin endpoints under /admin/kafka.
+// Requests must send kafka.admin.token in AdminTokenHeader; without a configured
+// token the endpoints are not registered.
+func RegisterKafkaAdmin(router fiber.Router) {
+	token := config.ViperGet[string]("kafka.admin.token")
+	if token == "" {
+		slog.Error("kafka admin endpoints are not registered, kafka.admin.token is empty")
+		return
+	}
+	group := router.Group("/admin/kafka", func(ctx *fiber.Ctx) error {
+		if subtle.ConstantTimeCompare([]byte(ctx.Get(AdminTokenHeader)), []byte(token)) != 1 {
+			ctx.Status(fiber.StatusUnauthorized)
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid admin token")
+		}
+		if kafka.Instance() == nil {
+			ctx.Status(fiber.StatusServiceUnavailable)
+			return fiber.NewError(fiber.StatusServiceUnavailable, "kafka service is not initialized")
+		}
+		return ctx.Next()
+	})
+	group.Get("/consumers", func(ctx *fiber.Ctx) error {
+		status, err := kafka.Instance().Status(ctx.UserContext())
+		if err != nil {
+			return err
+		}
+		return ctx.JSON(handler.OkWithData(status))
+	})
+	group.Get("/consumers/:topic", func(ctx *fiber.Ctx) error {
+		status, err := kafka.Instance().TopicStatus(ctx.UserContext(), ctx.Params("topic"))
+		if err != nil {
+			return consumerError(ctx, err)
+		}
+		return ctx.JSON(handler.OkWithData(status))
+	})
+	group.Post("/consumers/:topic/pause", func(ctx *fiber.Ctx) error {
+		if err := kafka.Instance().Pause(ctx.Params("topic")); err != nil {
+			return consumerError(ctx, err)
+		}
+		return ctx.JSON(handler.Ok())
+	})
+	group.Post("/consumers/:topic/resume", func(ctx *fiber.Ctx) error {
+		if err := kafka.Instance().Resume(ctx.Params("topic")); err != nil {
+			return consumerError(ctx, err)
+		}
+		return ctx.JSON(handler.Ok())
+	})
+}
+
+func consumerError(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, kafka.ErrConsumerNotFound) {
+		ctx.Status(fiber.StatusNotFound)
+		return fiber.NewError(fiber.StatusNotFound, "consumer not found: "+ctx.Params("topic"))
+	}
+	return err
+}
@@ -2,8 +2,20 @@ package route
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"github.com/wnnce/fserv-template/config"
+	"github.com/wnnce/fserv-template/internal/middleware"
 )
 
+// RegisterRouter registers the business routes. Routes that change state are
+// registered on the /api group, which applies the idempotency middleware when
+// idempotency.enable is true; add the authentication middleware to the group
+// before it, so that idempotency keys are scoped per user.
 func RegisterRouter(app *fiber.App) {
-	// TODO
+	api := app.Group("/api")
+	if config.ViperGet[bool]("idempotency.enable", false) {
+		api.Use(middleware.IdempotencyMiddleware(middleware.ViperIdempotencyConfig()))
+	}
+	if config.ViperGet[bool]("kafka.admin.enable", false) {
+		RegisterKafkaAdmin(app)
+	}
 }
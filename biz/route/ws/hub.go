@@ -0,0 +1,334 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/fasthttp/websocket"
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/config"
+)
+
+// hub.go
+//
+// Description: fans messages out to websocket sessions on every instance.
+// Each instance keeps a registry of its own sessions by user and room, and
+// every published message is delivered locally and relayed over a Redis
+// pub/sub channel to the other instances, which deliver it to their matching
+// sessions. Every session has its own delivery queue and writer goroutine, so
+// a slow client only delays its own messages.
+
+// Hub target kinds.
+const (
+	TargetUser      = "user"
+	TargetRoom      = "room"
+	TargetBroadcast = "broadcast"
+)
+
+// Target selects the sessions a message is delivered to.
+type Target struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id,omitempty"`
+}
+
+// ToUser targets every session registered for userID.
+func ToUser(userID string) Target {
+	return Target{Kind: TargetUser, ID: userID}
+}
+
+// ToRoom targets every session that joined room.
+func ToRoom(room string) Target {
+	return Target{Kind: TargetRoom, ID: room}
+}
+
+// ToAll targets every registered session.
+func ToAll() Target {
+	return Target{Kind: TargetBroadcast}
+}
+
+// hubMessage is the envelope relayed between instances.
+type hubMessage struct {
+	Origin      string `json:"origin"`
+	Target      Target `json:"target"`
+	MessageType int    `json:"messageType"`
+	Payload     []byte `json:"payload"`
+}
+
+// hubQueueSize is the number of messages queued for a session before further
+// messages to it are dropped.
+const hubQueueSize = 64
+
+// hubDelivery is a message queued for one session.
+type hubDelivery struct {
+	target      Target
+	messageType int
+	payload     []byte
+}
+
+// hubMember is the registry entry of a session.
+type hubMember struct {
+	userID string
+	rooms  map[string]struct{}
+	queue  chan hubDelivery
+}
+
+// Hub is the registry of the sessions of this instance and the publisher
+// that reaches sessions on other instances.
+//
+// Example:
+//
+//	session := ws.NewWebsocketSession(context.Background(), conn, handler)
+//	ws.DefaultHub().Register(session, userID)
+//	ws.DefaultHub().Join(session, "order:"+orderID)
+//	session.ReadLoop()
+//
+//	// on any instance
+//	err := ws.DefaultHub().PublishJSON(ctx, ws.ToRoom("order:"+orderID), event)
+type Hub struct {
+	origin       string
+	channel      string
+	writeTimeout time.Duration
+	mutex        sync.RWMutex
+	members      map[*WebsocketSession]*hubMember
+	users        map[string]map[*WebsocketSession]struct{}
+	rooms        map[string]map[*WebsocketSession]struct{}
+}
+
+var (
+	defaultHub = newHub("ws:fanout", 5*time.Second)
+)
+
+func newHub(channel string, writeTimeout time.Duration) *Hub {
+	return &Hub{
+		origin:       uuid.NewString(),
+		channel:      channel,
+		writeTimeout: writeTimeout,
+		members:      make(map[*WebsocketSession]*hubMember),
+		users:        make(map[string]map[*WebsocketSession]struct{}),
+		rooms:        make(map[string]map[*WebsocketSession]struct{}),
+	}
+}
+
+// DefaultHub returns the global Hub.
+func DefaultHub() *Hub {
+	return defaultHub
+}
+
+// InitHub subscribes the global Hub to the fan-out channel configured by
+// websocket.hub.channel. It must run after InitRedis; when Redis is not
+// initialized, the Hub only delivers to the sessions of this instance.
+func InitHub(ctx context.Context) (func(), error) {
+	defaultHub.channel = config.ViperGet[string]("websocket.hub.channel", defaultHub.channel)
+	defaultHub.writeTimeout = config.ViperGet[time.Duration]("websocket.hub.write-timeout", defaultHub.writeTimeout)
+	client := redis.RedisClient()
+	if client == nil {
+		slog.Warn("redis is not initialized, websocket hub only delivers to local sessions")
+		return func() {}, nil
+	}
+	pubsub := client.Subscribe(ctx, defaultHub.channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	go func() {
+		for message := range pubsub.ChannelWithSubscriptions() {
+			switch v := message.(type) {
+			case *goredis.Subscription:
+				// the first subscription was confirmed by Receive above, so any
+				// later one is a resubscription after a reconnect.
+				slog.Warn("websocket hub resubscribed, messages published while disconnected were lost",
+					slog.String("channel", defaultHub.channel))
+			case *goredis.Message:
+				defaultHub.receive(v.Payload)
+			}
+		}
+		slog.Info("websocket hub subscriber exit")
+	}()
+	return func() {
+		_ = pubsub.Close()
+	}, nil
+}
+
+// Register adds session to the hub under userID, which may be empty for
+// anonymous sessions. The session is removed automatically when it shuts down.
+func (self *Hub) Register(session *WebsocketSession, userID string) {
+	self.mutex.Lock()
+	if _, ok := self.members[session]; ok {
+		self.mutex.Unlock()
+		return
+	}
+	member := &hubMember{
+		userID: userID,
+		rooms:  make(map[string]struct{}),
+		queue:  make(chan hubDelivery, hubQueueSize),
+	}
+	self.members[session] = member
+	if userID != "" {
+		addHubSession(self.users, userID, session)
+	}
+	self.mutex.Unlock()
+	go self.write(session, member)
+}
+
+// write delivers the messages queued for session in order until the session
+// shuts down, then unregisters it.
+func (self *Hub) write(session *WebsocketSession, member *hubMember) {
+	defer self.Unregister(session)
+	for {
+		select {
+		case <-session.Context().Done():
+			return
+		case delivery := <-member.queue:
+			if err := session.WriteWithTimeout(delivery.messageType, delivery.payload, self.writeTimeout); err != nil {
+				slog.Warn("websocket hub delivery failed", slog.String("target", delivery.target.Kind+":"+delivery.target.ID),
+					slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// Unregister removes session and all of its room memberships.
+func (self *Hub) Unregister(session *WebsocketSession) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	member, ok := self.members[session]
+	if !ok {
+		return
+	}
+	delete(self.members, session)
+	if member.userID != "" {
+		removeHubSession(self.users, member.userID, session)
+	}
+	for room := range member.rooms {
+		removeHubSession(self.rooms, room, session)
+	}
+}
+
+// Join adds a registered session to room.
+func (self *Hub) Join(session *WebsocketSession, room string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	member, ok := self.members[session]
+	if !ok {
+		return
+	}
+	member.rooms[room] = struct{}{}
+	addHubSession(self.rooms, room, session)
+}
+
+// Leave removes session from room.
+func (self *Hub) Leave(session *WebsocketSession, room string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if member, ok := self.members[session]; ok {
+		delete(member.rooms, room)
+	}
+	removeHubSession(self.rooms, room, session)
+}
+
+// Publish delivers payload to the sessions matching target on every instance.
+// Delivery is best effort: messages are dropped for sessions whose queue is
+// full or that fail to receive them within the write timeout, and messages
+// published while an instance is disconnected from Redis are not replayed to it.
+func (self *Hub) Publish(ctx context.Context, target Target, messageType int, payload []byte) error {
+	self.deliver(target, messageType, payload)
+	message, err := sonic.Marshal(hubMessage{
+		Origin:      self.origin,
+		Target:      target,
+		MessageType: messageType,
+		Payload:     payload,
+	})
+	if err != nil {
+		return err
+	}
+	client := redis.RedisClient()
+	if client == nil {
+		return nil
+	}
+	return client.Publish(ctx, self.channel, message).Err()
+}
+
+// PublishJSON publishes value encoded as JSON in a text message.
+func (self *Hub) PublishJSON(ctx context.Context, target Target, value any) error {
+	payload, err := sonic.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return self.Publish(ctx, target, websocket.TextMessage, payload)
+}
+
+// receive handles a message relayed by another instance.
+func (self *Hub) receive(payload string) {
+	var message hubMessage
+	if err := sonic.UnmarshalString(payload, &message); err != nil {
+		slog.Warn("websocket hub message decode failed", slog.String("error", err.Error()))
+		return
+	}
+	if message.Origin == self.origin {
+		return
+	}
+	self.deliver(message.Target, message.MessageType, message.Payload)
+}
+
+// deliver queues the message for the local sessions matching target without
+// waiting for the writes, so a slow session neither delays the others nor the
+// subscriber. Each session receives its messages in order.
+func (self *Hub) deliver(target Target, messageType int, payload []byte) {
+	delivery := hubDelivery{target: target, messageType: messageType, payload: payload}
+	for _, member := range self.match(target) {
+		select {
+		case member.queue <- delivery:
+		default:
+			slog.Warn("websocket hub session queue is full, message dropped",
+				slog.String("target", target.Kind+":"+target.ID))
+		}
+	}
+}
+
+func (self *Hub) match(target Target) []*hubMember {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	var matched map[*WebsocketSession]struct{}
+	switch target.Kind {
+	case TargetUser:
+		matched = self.users[target.ID]
+	case TargetRoom:
+		matched = self.rooms[target.ID]
+	case TargetBroadcast:
+		result := make([]*hubMember, 0, len(self.members))
+		for _, member := range self.members {
+			result = append(result, member)
+		}
+		return result
+	}
+	result := make([]*hubMember, 0, len(matched))
+	for session := range matched {
+		result = append(result, self.members[session])
+	}
+	return result
+}
+
+func addHubSession(index map[string]map[*WebsocketSession]struct{}, key string, session *WebsocketSession) {
+	sessions, ok := index[key]
+	if !ok {
+		sessions = make(map[*WebsocketSession]struct{})
+		index[key] = sessions
+	}
+	sessions[session] = struct{}{}
+}
+
+func removeHubSession(index map[string]map[*WebsocketSession]struct{}, key string, session *WebsocketSession) {
+	sessions, ok := index[key]
+	if !ok {
+		return
+	}
+	delete(sessions, session)
+	if len(sessions) == 0 {
+		delete(index, key)
+	}
+}
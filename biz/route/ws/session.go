@@ -159,6 +159,23 @@ func (self *WebsocketSession) Write(messageType int, message []byte) error {
 	return self.conn.WriteMessage(messageType, message)
 }
 
+// WriteWithTimeout sends a message like Write, but gives up once timeout
+// elapses so that a stalled client cannot block the caller indefinitely.
+func (self *WebsocketSession) WriteWithTimeout(messageType int, message []byte, timeout time.Duration) error {
+	if err := self.ctx.Err(); err != nil {
+		return err
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if err := self.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer func() {
+		_ = self.conn.SetWriteDeadline(time.Time{})
+	}()
+	return self.conn.WriteMessage(messageType, message)
+}
+
 func (self *WebsocketSession) WriteAsync(messageType int, message []byte, callback func(err error)) {
 	go func() {
 		if err := self.Write(messageType, message); callback != nil {
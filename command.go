@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wnnce/fserv-template/biz/dal/mg"
+	"github.com/wnnce/fserv-template/biz/mw/kafka"
+	"github.com/wnnce/fserv-template/config"
+)
+
+// command is a CLI subcommand that runs instead of the HTTP server, e.g.
+// `fserv-template mongo indexes diff`.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{
+		name:  "mongo indexes diff",
+		usage: "show drift between declared and actual MongoDB indexes",
+		run:   mongoIndexesDiff,
+	},
+	{
+		name:  "mongo indexes sync",
+		usage: "create missing MongoDB indexes; --rebuild-changed drops and recreates changed ones, --drop-undeclared drops undeclared ones",
+		run:   mongoIndexesSync,
+	},
+	{
+		name:  "mongo migrate up",
+		usage: "apply pending MongoDB migrations",
+		run:   mongoMigrateUp,
+	},
+	{
+		name:  "mongo migrate status",
+		usage: "list MongoDB migrations and whether they are applied",
+		run:   mongoMigrateStatus,
+	},
+	{
+		name:  "kafka topics",
+		usage: "list Kafka topics with their partitions, replication and declaration drift",
+		run:   kafkaTopics,
+	},
+	{
+		name:  "kafka lag",
+		usage: "show consumer group lag per partition, for kafka.consumer-group or the given groups",
+		run:   kafkaLag,
+	},
+}
+
+// runCommand finds the subcommand matching the leading args and runs it with
+// the remaining args.
+func runCommand(ctx context.Context, args []string) error {
+	for _, cmd := range commands {
+		fields := strings.Fields(cmd.name)
+		if len(args) < len(fields) || strings.Join(args[:len(fields)], " ") != cmd.name {
+			continue
+		}
+		return cmd.run(ctx, args[len(fields):])
+	}
+	writer := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "available commands:")
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(writer, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	_ = writer.Flush()
+	return errors.New("unknown command: " + strings.Join(args, " "))
+}
+
+// mongoIndexesDiff prints the drift between the indexes declared with
+// mg.RegisterIndexes and the database, and fails when any drift is found.
+func mongoIndexesDiff(ctx context.Context, _ []string) error {
+	cleanup, err := mg.ConnectMongoDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	diffs, err := mg.DiffIndexes(ctx)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		fmt.Println("mongo indexes are in sync")
+		return nil
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "COLLECTION\tINDEX\tTYPE\tDETAIL")
+	for _, diff := range diffs {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", diff.Collection, diff.Name, diff.Type, diff.Detail)
+	}
+	_ = writer.Flush()
+	return mg.ErrIndexDrift
+}
+
+// mongoIndexesSync reconciles the indexes declared with mg.RegisterIndexes. Changed
+// and undeclared indexes are only changed when the matching flag is given.
+func mongoIndexesSync(ctx context.Context, args []string) error {
+	var dropUndeclared, rebuildChanged bool
+	for _, arg := range args {
+		switch arg {
+		case "--drop-undeclared":
+			dropUndeclared = true
+		case "--rebuild-changed":
+			rebuildChanged = true
+		default:
+			return errors.New("unknown flag: " + arg)
+		}
+	}
+	cleanup, err := mg.ConnectMongoDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	diffs, err := mg.SyncIndexes(ctx, dropUndeclared, rebuildChanged)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		fmt.Println("mongo indexes are in sync")
+	}
+	return nil
+}
+
+// mongoMigrateUp applies every pending migration registered with mg.RegisterMigrations.
+func mongoMigrateUp(ctx context.Context, _ []string) error {
+	cleanup, err := mg.ConnectMongoDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	versions, err := mg.Migrate(ctx, config.ViperGet[time.Duration]("mongo.migration.lock-timeout", time.Minute))
+	for _, version := range versions {
+		fmt.Println("applied", version)
+	}
+	if err == nil && len(versions) == 0 {
+		fmt.Println("no pending mongo migrations")
+	}
+	return err
+}
+
+// mongoMigrateStatus prints every registered migration and when it was applied.
+func mongoMigrateStatus(ctx context.Context, _ []string) error {
+	cleanup, err := mg.ConnectMongoDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	states, err := mg.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+	for _, state := range states {
+		appliedAt := "pending"
+		if state.Applied {
+			appliedAt = state.AppliedAt.Local().Format(time.DateTime)
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", strconv.FormatInt(state.Version, 10), state.Name, appliedAt)
+	}
+	return writer.Flush()
+}
+
+// kafkaTopics prints every topic of the cluster and the drift between the
+// topics declared with kafka.RegisterTopics and the cluster.
+func kafkaTopics(ctx context.Context, _ []string) error {
+	admin, cleanup, err := kafka.ConnectKafkaAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	details, err := admin.ListTopics(ctx)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "TOPIC\tPARTITIONS\tREPLICAS")
+	for _, detail := range details.Sorted() {
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%d\n", detail.Topic, len(detail.Partitions), detail.Partitions.NumReplicas())
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	diffs, err := kafka.DiffTopics(ctx, admin)
+	if err != nil || len(diffs) == 0 {
+		return err
+	}
+	fmt.Println()
+	_, _ = fmt.Fprintln(writer, "TOPIC\tTYPE\tDETAIL")
+	for _, diff := range diffs {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", diff.Topic, diff.Type, diff.Detail)
+	}
+	return writer.Flush()
+}
+
+// kafkaLag prints the lag of every partition consumed by the given groups,
+// defaulting to kafka.consumer-group.
+func kafkaLag(ctx context.Context, args []string) error {
+	groups := args
+	if len(groups) == 0 {
+		groups = []string{config.ViperGet[string]("kafka.consumer-group")}
+	}
+	admin, cleanup, err := kafka.ConnectKafkaAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	lags, err := admin.Lag(ctx, groups...)
+	if err != nil {
+		return err
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "GROUP\tSTATE\tTOPIC\tPARTITION\tCOMMITTED\tEND\tLAG\tMEMBER")
+	for _, group := range lags.Sorted() {
+		if err = group.Error(); err != nil {
+			return fmt.Errorf("kafka group %s: %w", group.Group, err)
+		}
+		for _, lag := range group.Lag.Sorted() {
+			member := "-"
+			if lag.Member != nil {
+				member = lag.Member.ClientID + "@" + lag.Member.ClientHost
+			}
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", group.Group, group.State,
+				lag.Topic, lag.Partition, lag.Commit.At, lag.End.Offset, lag.Lag, member)
+		}
+	}
+	return writer.Flush()
+}
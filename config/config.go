@@ -138,3 +138,15 @@ func defaultOrZero[T any](defaults []T) T {
 	}
 	return zero
 }
+
+// ViperUnmarshal decodes the value at key into T, e.g. a slice of structs
+// tagged with `mapstructure`. Durations may be written as "1m30s". A missing
+// key yields the zero value of T.
+func ViperUnmarshal[T any](key string) (T, error) {
+	var result T
+	if !viper.IsSet(key) {
+		return result, nil
+	}
+	err := viper.UnmarshalKey(key, &result)
+	return result, err
+}
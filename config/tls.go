@@ -0,0 +1,50 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// ViperTLSConfig builds a *tls.Config from the keys under prefix, e.g.
+// "mongo.tls". It returns nil when <prefix>.enable is false, so the result
+// can be passed straight to client options that treat nil as plain text.
+//
+// Supported keys:
+//
+//	enable:               turn TLS on
+//	ca-file:              PEM encoded CA bundle used to verify the server
+//	cert-file, key-file:  PEM encoded client certificate and key for mutual TLS
+//	server-name:          overrides the server name used for verification
+//	insecure-skip-verify: disables server certificate verification
+func ViperTLSConfig(prefix string) (*tls.Config, error) {
+	if !ViperGet[bool](prefix+".enable", false) {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         ViperGet[string](prefix + ".server-name"),
+		InsecureSkipVerify: ViperGet[bool](prefix+".insecure-skip-verify", false), //nolint:gosec // explicitly opted in by configuration
+	}
+	if caFile := ViperGet[string](prefix + ".ca-file"); caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no valid certificate found in " + caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	certFile, keyFile := ViperGet[string](prefix+".cert-file"), ViperGet[string](prefix+".key-file")
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
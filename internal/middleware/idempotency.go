@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gofiber/fiber/v2"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/config"
+)
+
+// Idempotency record states.
+const (
+	idempotencyProcessing = "processing"
+	idempotencyDone       = "done"
+)
+
+// IdempotencyStore persists idempotency records by key. Get returns nil data
+// without an error when the key does not exist or has expired.
+type IdempotencyStore interface {
+	// Acquire stores data only if key does not exist and reports whether it did.
+	Acquire(ctx context.Context, key string, data []byte, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// IdempotencyConfig defines the configuration for the idempotency middleware.
+type IdempotencyConfig struct {
+	Header   string           // Request header carrying the idempotency key.
+	Methods  []string         // Methods the middleware applies to.
+	Prefix   string           // Prefix of every storage key.
+	TTL      time.Duration    // How long a completed response is replayed.
+	LockTTL  time.Duration    // How long the in-progress marker survives, it should exceed the slowest handler.
+	Required bool             // If true, requests without the header are rejected with 400.
+	Store    IdempotencyStore // Defaults to the Redis store, or to the memory store when Redis is not initialized.
+}
+
+// DefaultIdempotencyConfig provides a default configuration for the idempotency middleware.
+var DefaultIdempotencyConfig = IdempotencyConfig{
+	Header:  "Idempotency-Key",
+	Methods: []string{fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch},
+	Prefix:  "idempotency",
+	TTL:     24 * time.Hour,
+	LockTTL: time.Minute,
+}
+
+// ViperIdempotencyConfig reads the idempotency configuration under "idempotency".
+func ViperIdempotencyConfig() IdempotencyConfig {
+	cfg := IdempotencyConfig{
+		Header:   config.ViperGet[string]("idempotency.header", DefaultIdempotencyConfig.Header),
+		Methods:  config.ViperGet[[]string]("idempotency.methods", DefaultIdempotencyConfig.Methods),
+		Prefix:   config.ViperGet[string]("idempotency.prefix", DefaultIdempotencyConfig.Prefix),
+		TTL:      config.ViperGet[time.Duration]("idempotency.ttl", DefaultIdempotencyConfig.TTL),
+		LockTTL:  config.ViperGet[time.Duration]("idempotency.lock-ttl", DefaultIdempotencyConfig.LockTTL),
+		Required: config.ViperGet[bool]("idempotency.required", false),
+	}
+	if config.ViperGet[string]("idempotency.store", "redis") == "memory" {
+		cfg.Store = NewMemoryIdempotencyStore(config.ViperGet[int]("idempotency.memory-capacity", 10000))
+	}
+	return cfg
+}
+
+// idempotencyConfigDefault fills in any missing values in the given IdempotencyConfig
+// with defaults from DefaultIdempotencyConfig.
+func idempotencyConfigDefault(cfg *IdempotencyConfig) {
+	if cfg.Header == "" {
+		cfg.Header = DefaultIdempotencyConfig.Header
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = DefaultIdempotencyConfig.Methods
+	}
+	for i, method := range cfg.Methods {
+		cfg.Methods[i] = strings.ToUpper(method)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultIdempotencyConfig.Prefix
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultIdempotencyConfig.TTL
+	}
+	if cfg.LockTTL <= 0 {
+		cfg.LockTTL = DefaultIdempotencyConfig.LockTTL
+	}
+	if cfg.Store == nil {
+		if redis.RedisClient() != nil {
+			cfg.Store = NewRedisIdempotencyStore()
+		} else {
+			slog.Warn("redis is not initialized, idempotency falls back to the memory store")
+			cfg.Store = NewMemoryIdempotencyStore(10000)
+		}
+	}
+}
+
+// idempotencyRecord is stored under every idempotency key.
+type idempotencyRecord struct {
+	State       string      `json:"state"`
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Headers     [][2]string `json:"headers,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyMiddleware creates a Fiber middleware handler that executes each
+// request carrying an idempotency key at most once.
+//
+// The first request stores an in-progress marker and runs the handler.
+// Concurrent duplicates get 409 while it runs, and once it completes the
+// status, headers and body are stored for TTL and replayed to every retry with
+// an Idempotent-Replayed header. A key reused with a different method, path or
+// body gets 422. Failed requests (a returned error, a 5xx status or a panic)
+// release the key so that the client can retry.
+//
+// Keys are scoped by the userId context value, so the middleware must be
+// registered on the route groups it protects, after their authentication
+// middleware; requests without a user share the anonymous scope.
+//
+// Example:
+//
+//	api := app.Group("/api", authMiddleware, middleware.IdempotencyMiddleware(middleware.ViperIdempotencyConfig()))
+func IdempotencyMiddleware(config IdempotencyConfig) fiber.Handler {
+	idempotencyConfigDefault(&config)
+	return func(ctx *fiber.Ctx) error {
+		if !slices.Contains(config.Methods, ctx.Method()) {
+			return ctx.Next()
+		}
+		idempotencyKey := ctx.Get(config.Header)
+		if idempotencyKey == "" {
+			if config.Required {
+				ctx.Status(fiber.StatusBadRequest)
+				return fiber.NewError(fiber.StatusBadRequest, "missing "+config.Header+" header")
+			}
+			return ctx.Next()
+		}
+		key := config.Prefix + ":" + idempotencyScope(ctx) + ":" + idempotencyKey
+		fingerprint := idempotencyFingerprint(ctx)
+		userCtx := ctx.UserContext()
+		store := config.Store
+
+		marker, err := sonic.Marshal(idempotencyRecord{State: idempotencyProcessing, Fingerprint: fingerprint})
+		if err != nil {
+			return err
+		}
+		acquired, err := store.Acquire(userCtx, key, marker, config.LockTTL)
+		if err != nil {
+			slog.ErrorContext(userCtx, "idempotency store failed", slog.String("key", key), slog.String("error", err.Error()))
+			ctx.Status(fiber.StatusServiceUnavailable)
+			return fiber.NewError(fiber.StatusServiceUnavailable, "idempotency store unavailable")
+		}
+		if !acquired {
+			return replayIdempotent(ctx, store, key, fingerprint)
+		}
+
+		completed := false
+		defer func() {
+			// runs on failures and while a handler panic unwinds to the recover middleware.
+			if completed {
+				return
+			}
+			if delErr := store.Delete(context.WithoutCancel(userCtx), key); delErr != nil {
+				slog.ErrorContext(userCtx, "idempotency release failed", slog.String("key", key), slog.String("error", delErr.Error()))
+			}
+		}()
+		if err = ctx.Next(); err != nil || ctx.Response().StatusCode() >= fiber.StatusInternalServerError {
+			return err
+		}
+		// the handler succeeded, so the key is kept even if the response cannot be saved.
+		completed = true
+		record := idempotencyRecord{
+			State:       idempotencyDone,
+			Fingerprint: fingerprint,
+			Status:      ctx.Response().StatusCode(),
+			Body:        ctx.Response().Body(),
+		}
+		ctx.Response().Header.VisitAll(func(name, value []byte) {
+			switch string(name) {
+			case fiber.HeaderDate, fiber.HeaderContentLength, fiber.HeaderSetCookie:
+				return
+			}
+			record.Headers = append(record.Headers, [2]string{string(name), string(value)})
+		})
+		data, err := sonic.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err = store.Set(userCtx, key, data, config.TTL); err != nil {
+			slog.ErrorContext(userCtx, "idempotency save failed", slog.String("key", key), slog.String("error", err.Error()))
+		}
+		return nil
+	}
+}
+
+// replayIdempotent answers a request whose key is already taken.
+func replayIdempotent(ctx *fiber.Ctx, store IdempotencyStore, key, fingerprint string) error {
+	data, err := store.Get(ctx.UserContext(), key)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		// the previous attempt failed and released the key in the meantime.
+		ctx.Status(fiber.StatusConflict)
+		return fiber.NewError(fiber.StatusConflict, "request with the same idempotency key is being retried")
+	}
+	var record idempotencyRecord
+	if err = sonic.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if record.Fingerprint != fingerprint {
+		ctx.Status(fiber.StatusUnprocessableEntity)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "idempotency key was used with a different request")
+	}
+	if record.State == idempotencyProcessing {
+		ctx.Status(fiber.StatusConflict)
+		return fiber.NewError(fiber.StatusConflict, "request with the same idempotency key is in progress")
+	}
+	for _, header := range record.Headers {
+		ctx.Response().Header.Add(header[0], header[1])
+	}
+	ctx.Set("Idempotent-Replayed", "true")
+	ctx.Status(record.Status)
+	return ctx.Send(record.Body)
+}
+
+// idempotencyScope separates the keys of different users.
+func idempotencyScope(ctx *fiber.Ctx) string {
+	if userID := contextUserID(ctx.UserContext()); userID != "" {
+		return "user:" + userID
+	}
+	return "anonymous"
+}
+
+// idempotencyFingerprint hashes the method, path, query and body of the request.
+func idempotencyFingerprint(ctx *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Method()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(ctx.OriginalURL()))
+	hash.Write([]byte{0})
+	hash.Write(ctx.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/pkg/tool"
+)
+
+// redisIdempotencyStore keeps idempotency records in the default Redis client.
+type redisIdempotencyStore struct{}
+
+// NewRedisIdempotencyStore creates an IdempotencyStore backed by the default Redis client.
+func NewRedisIdempotencyStore() IdempotencyStore {
+	return redisIdempotencyStore{}
+}
+
+func (self redisIdempotencyStore) Acquire(ctx context.Context, key string, data []byte, ttl time.Duration) (bool, error) {
+	return redis.RedisClient().SetNX(ctx, key, data, ttl).Result()
+}
+
+func (self redisIdempotencyStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := redis.RedisClient().Get(ctx, key).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (self redisIdempotencyStore) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return redis.RedisClient().Set(ctx, key, data, ttl).Err()
+}
+
+func (self redisIdempotencyStore) Delete(ctx context.Context, key string) error {
+	return redis.RedisClient().Del(ctx, key).Err()
+}
+
+// memoryIdempotencyStore keeps idempotency records in process. It is meant for
+// development without Redis: keys are per instance and lost on restart.
+type memoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	records *tool.LRU[string, []byte]
+}
+
+// NewMemoryIdempotencyStore creates an in-process IdempotencyStore holding at most capacity keys.
+func NewMemoryIdempotencyStore(capacity int) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		records: tool.NewLRU[string, []byte](capacity, nil),
+	}
+}
+
+func (self *memoryIdempotencyStore) Acquire(_ context.Context, key string, data []byte, ttl time.Duration) (bool, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if _, ok := self.records.Get(key); ok {
+		return false, nil
+	}
+	self.records.Set(key, data, ttl)
+	return true, nil
+}
+
+func (self *memoryIdempotencyStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, _ := self.records.Get(key)
+	return data, nil
+}
+
+func (self *memoryIdempotencyStore) Set(_ context.Context, key string, data []byte, ttl time.Duration) error {
+	self.records.Set(key, data, ttl)
+	return nil
+}
+
+func (self *memoryIdempotencyStore) Delete(_ context.Context, key string) error {
+	self.records.Delete(key)
+	return nil
+}
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/config"
+	"github.com/wnnce/fserv-template/internal/constat"
+)
+
+// Rate limit algorithms.
+const (
+	FixedWindow   = "fixed-window"   // FixedWindow counts requests in consecutive windows of fixed length.
+	SlidingWindow = "sliding-window" // SlidingWindow counts requests in the window ending now.
+	TokenBucket   = "token-bucket"   // TokenBucket refills Limit tokens per Window up to Burst.
+)
+
+// Rate limit key sources.
+const (
+	KeyByIP     = "ip"      // KeyByIP limits each client IP.
+	KeyByUser   = "user"    // KeyByUser limits each userId context value, falling back to the IP.
+	KeyByAPIKey = "api-key" // KeyByAPIKey limits each API key header, falling back to the IP.
+	KeyByRoute  = "route"   // KeyByRoute shares one limit between all clients of a route.
+)
+
+// RateLimitRule describes the limit applied to the requests matching Method and Path.
+// A Path ending in "*" matches every path with that prefix, and an empty
+// Method matches every method.
+type RateLimitRule struct {
+	Method    string        `mapstructure:"method"`
+	Path      string        `mapstructure:"path"`
+	Algorithm string        `mapstructure:"algorithm"`
+	Key       string        `mapstructure:"key"`
+	Limit     int           `mapstructure:"limit"`
+	Window    time.Duration `mapstructure:"window"`
+	Burst     int           `mapstructure:"burst"` // Bucket capacity of TokenBucket; defaults to Limit.
+}
+
+// RateLimitResult is the outcome of taking one request from a limit.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Time until the limit is fully restored.
+	RetryAfter time.Duration // Time until the next request is allowed, when denied.
+}
+
+// RateLimitStore applies a rule to a key atomically.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+// RateLimitConfig defines the configuration for the rate limit middleware.
+type RateLimitConfig struct {
+	Prefix       string          // Prefix of every storage key.
+	Default      RateLimitRule   // Rule for requests that match none of Routes; a zero Limit disables it.
+	Routes       []RateLimitRule // Per-route rules, the first match wins.
+	APIKeyHeader string          // Header holding the API key for KeyByAPIKey.
+	FailOpen     bool            // If true, requests are allowed when the store fails, otherwise they are rejected with 503.
+	Store        RateLimitStore  // Defaults to the Redis store, or to the memory store when Redis is not initialized.
+}
+
+// DefaultRateLimitConfig provides a default configuration for the rate limit middleware.
+var DefaultRateLimitConfig = RateLimitConfig{
+	Prefix: "rate-limit",
+	Default: RateLimitRule{
+		Algorithm: SlidingWindow,
+		Key:       KeyByIP,
+		Limit:     100,
+		Window:    time.Minute,
+	},
+	APIKeyHeader: "X-API-Key",
+	FailOpen:     true,
+}
+
+// ViperRateLimitConfig reads the rate limit configuration under "rate-limit".
+// Rules that omit the algorithm, key or window inherit them from the default rule.
+func ViperRateLimitConfig() (RateLimitConfig, error) {
+	routes, err := config.ViperUnmarshal[[]RateLimitRule]("rate-limit.routes")
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+	cfg := RateLimitConfig{
+		Prefix: config.ViperGet[string]("rate-limit.prefix", DefaultRateLimitConfig.Prefix),
+		Default: RateLimitRule{
+			Algorithm: config.ViperGet[string]("rate-limit.algorithm", DefaultRateLimitConfig.Default.Algorithm),
+			Key:       config.ViperGet[string]("rate-limit.key", DefaultRateLimitConfig.Default.Key),
+			Limit:     config.ViperGet[int]("rate-limit.limit", DefaultRateLimitConfig.Default.Limit),
+			Window:    config.ViperGet[time.Duration]("rate-limit.window", DefaultRateLimitConfig.Default.Window),
+			Burst:     config.ViperGet[int]("rate-limit.burst"),
+		},
+		Routes:       routes,
+		APIKeyHeader: config.ViperGet[string]("rate-limit.api-key-header", DefaultRateLimitConfig.APIKeyHeader),
+		FailOpen:     config.ViperGet[bool]("rate-limit.fail-open", DefaultRateLimitConfig.FailOpen),
+	}
+	if config.ViperGet[string]("rate-limit.store", "redis") == "memory" {
+		cfg.Store = NewMemoryRateLimitStore(config.ViperGet[int]("rate-limit.memory-capacity", 10000))
+	}
+	return cfg, nil
+}
+
+// rateLimitConfigDefault fills in any missing values in the given RateLimitConfig
+// with defaults from DefaultRateLimitConfig.
+func rateLimitConfigDefault(cfg *RateLimitConfig) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultRateLimitConfig.Prefix
+	}
+	if cfg.APIKeyHeader == "" {
+		cfg.APIKeyHeader = DefaultRateLimitConfig.APIKeyHeader
+	}
+	ruleDefault(&cfg.Default, DefaultRateLimitConfig.Default)
+	for i := range cfg.Routes {
+		ruleDefault(&cfg.Routes[i], cfg.Default)
+	}
+	if cfg.Store == nil {
+		if redis.RedisClient() != nil {
+			cfg.Store = NewRedisRateLimitStore()
+		} else {
+			slog.Warn("redis is not initialized, rate limit falls back to the memory store")
+			cfg.Store = NewMemoryRateLimitStore(10000)
+		}
+	}
+}
+
+func ruleDefault(rule *RateLimitRule, defaults RateLimitRule) {
+	if rule.Algorithm == "" {
+		rule.Algorithm = defaults.Algorithm
+	}
+	if rule.Key == "" {
+		rule.Key = defaults.Key
+	}
+	if rule.Window <= 0 {
+		rule.Window = defaults.Window
+	}
+	if rule.Burst <= 0 {
+		rule.Burst = rule.Limit
+	}
+	rule.Method = strings.ToUpper(rule.Method)
+}
+
+// RateLimitMiddleware creates a Fiber middleware handler that limits requests
+// according to the provided RateLimitConfig and reports the limit with the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers.
+// Rejected requests get 429 with a Retry-After header.
+//
+// To limit by KeyByUser, the middleware must be registered after the
+// middleware that stores the userId in the user context.
+func RateLimitMiddleware(config RateLimitConfig) fiber.Handler {
+	rateLimitConfigDefault(&config)
+	return func(ctx *fiber.Ctx) error {
+		rule, ruleName := matchRateLimitRule(&config, ctx.Method(), ctx.Path())
+		if rule.Limit <= 0 {
+			return ctx.Next()
+		}
+		key := config.Prefix + ":" + ruleName + ":" + rateLimitKey(ctx, rule, config.APIKeyHeader)
+		result, err := config.Store.Take(ctx.UserContext(), key, *rule)
+		if err != nil {
+			slog.ErrorContext(ctx.UserContext(), "rate limit store failed",
+				slog.String("key", key),
+				slog.Bool("failOpen", config.FailOpen),
+				slog.String("error", err.Error()),
+			)
+			if config.FailOpen {
+				return ctx.Next()
+			}
+			ctx.Status(fiber.StatusServiceUnavailable)
+			return fiber.NewError(fiber.StatusServiceUnavailable, "rate limit unavailable")
+		}
+		ctx.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		ctx.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Set("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+		if !result.Allowed {
+			ctx.Set(fiber.HeaderRetryAfter, strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+			ctx.Status(fiber.StatusTooManyRequests)
+			return fiber.NewError(fiber.StatusTooManyRequests, "too many requests")
+		}
+		return ctx.Next()
+	}
+}
+
+// matchRateLimitRule returns the first route rule matching the request, or the
+// default rule, together with a name that separates the counters of each rule.
+func matchRateLimitRule(cfg *RateLimitConfig, method, path string) (*RateLimitRule, string) {
+	for i := range cfg.Routes {
+		rule := &cfg.Routes[i]
+		if rule.Method != "" && rule.Method != method {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(rule.Path, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return rule, rule.Method + rule.Path
+			}
+			continue
+		}
+		if rule.Path == path {
+			return rule, rule.Method + rule.Path
+		}
+	}
+	return &cfg.Default, "default"
+}
+
+func rateLimitKey(ctx *fiber.Ctx, rule *RateLimitRule, apiKeyHeader string) string {
+	switch rule.Key {
+	case KeyByUser:
+		if userID := contextUserID(ctx.UserContext()); userID != "" {
+			return "user:" + userID
+		}
+	case KeyByAPIKey:
+		if apiKey := ctx.Get(apiKeyHeader); apiKey != "" {
+			return "key:" + apiKey
+		}
+	case KeyByRoute:
+		return "route:" + ctx.Method() + ctx.Path()
+	}
+	return "ip:" + ctx.IP()
+}
+
+// contextUserID returns the userId context value as a string, or an empty
+// string when it is not set.
+func contextUserID(ctx context.Context) string {
+	switch userID := ctx.Value(constat.ContextUserIDKey).(type) {
+	case string:
+		return userID
+	case int64:
+		return strconv.FormatInt(userID, 10)
+	case int:
+		return strconv.Itoa(userID)
+	}
+	return ""
+}
+
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
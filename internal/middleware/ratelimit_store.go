@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/pkg/tool"
+)
+
+var (
+	// fixedWindowScript returns the request count of the current window and the
+	// milliseconds until it ends.
+	fixedWindowScript = goredis.NewScript(`
+local current = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {current, ttl}`)
+
+	// slidingWindowScript keeps the timestamps of the requests in the window in
+	// a sorted set and returns whether the request was admitted, the count and
+	// the milliseconds until the oldest request leaves the window.
+	slidingWindowScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}`)
+
+	// tokenBucketScript refills the bucket for the elapsed time, takes one
+	// token if available and returns whether it did, the tokens left and the
+	// milliseconds until the next token and until the bucket is full.
+	tokenBucketScript = goredis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+local full = math.ceil((capacity - tokens) / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], full + 1000)
+return {allowed, math.floor(tokens), wait, full}`)
+)
+
+// redisRateLimitStore runs every algorithm as a single Lua script on
+// RedisClient, so concurrent instances share exact counters. Timestamps come
+// from the calling instance, so instance clocks should be synchronized.
+type redisRateLimitStore struct{}
+
+// NewRedisRateLimitStore creates a RateLimitStore backed by the default Redis client.
+func NewRedisRateLimitStore() RateLimitStore {
+	return redisRateLimitStore{}
+}
+
+func (self redisRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	client := redis.RedisClient()
+	now := time.Now().UnixMilli()
+	window := rule.Window.Milliseconds()
+	switch rule.Algorithm {
+	case FixedWindow:
+		values, err := fixedWindowScript.Run(ctx, client, []string{key}, window).Int64Slice()
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return windowResult(rule.Limit, values[0] <= int64(rule.Limit), values[0], values[1]), nil
+	case SlidingWindow:
+		member := strconv.FormatInt(now, 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+		values, err := slidingWindowScript.Run(ctx, client, []string{key}, now, window, rule.Limit, member).Int64Slice()
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return windowResult(rule.Limit, values[0] == 1, values[1], values[2]), nil
+	case TokenBucket:
+		rate := strconv.FormatFloat(float64(rule.Limit)/float64(window), 'g', -1, 64)
+		values, err := tokenBucketScript.Run(ctx, client, []string{key}, rule.Burst, rate, now).Int64Slice()
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return RateLimitResult{
+			Allowed:    values[0] == 1,
+			Limit:      rule.Burst,
+			Remaining:  int(values[1]),
+			Reset:      time.Duration(values[3]) * time.Millisecond,
+			RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		}, nil
+	default:
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", rule.Algorithm)
+	}
+}
+
+// windowResult builds the result of a window algorithm from the request count
+// and the milliseconds until the window frees up.
+func windowResult(limit int, allowed bool, count, reset int64) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(0, limit-int(count)),
+		Reset:     time.Duration(reset) * time.Millisecond,
+	}
+	if !allowed {
+		result.RetryAfter = result.Reset
+	}
+	return result
+}
+
+// memoryRateLimitState holds the state of one key for any algorithm.
+type memoryRateLimitState struct {
+	count      int64
+	windowEnd  int64
+	timestamps []int64
+	tokens     float64
+	updatedAt  int64
+}
+
+// memoryRateLimitStore keeps limits in process. It is meant for development
+// without Redis: limits are per instance and lost on restart.
+type memoryRateLimitStore struct {
+	mutex  sync.Mutex
+	states *tool.LRU[string, *memoryRateLimitState]
+}
+
+// NewMemoryRateLimitStore creates an in-process RateLimitStore tracking at most capacity keys.
+func NewMemoryRateLimitStore(capacity int) RateLimitStore {
+	return &memoryRateLimitStore{
+		states: tool.NewLRU[string, *memoryRateLimitState](capacity, nil),
+	}
+}
+
+func (self *memoryRateLimitStore) Take(_ context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	now := time.Now().UnixMilli()
+	window := rule.Window.Milliseconds()
+	state, ok := self.states.Get(key)
+	if !ok {
+		state = &memoryRateLimitState{tokens: float64(rule.Burst), updatedAt: now}
+	}
+	var result RateLimitResult
+	switch rule.Algorithm {
+	case FixedWindow:
+		if now >= state.windowEnd {
+			state.count = 0
+			state.windowEnd = now + window
+		}
+		state.count++
+		result = windowResult(rule.Limit, state.count <= int64(rule.Limit), state.count, state.windowEnd-now)
+	case SlidingWindow:
+		first := 0
+		for first < len(state.timestamps) && state.timestamps[first] <= now-window {
+			first++
+		}
+		state.timestamps = state.timestamps[first:]
+		allowed := len(state.timestamps) < rule.Limit
+		if allowed {
+			state.timestamps = append(state.timestamps, now)
+		}
+		reset := window
+		if len(state.timestamps) > 0 {
+			reset = state.timestamps[0] + window - now
+		}
+		result = windowResult(rule.Limit, allowed, int64(len(state.timestamps)), reset)
+	case TokenBucket:
+		rate := float64(rule.Limit) / float64(window)
+		state.tokens = math.Min(float64(rule.Burst), state.tokens+float64(max(0, now-state.updatedAt))*rate)
+		state.updatedAt = now
+		result = RateLimitResult{Limit: rule.Burst}
+		if state.tokens >= 1 {
+			state.tokens--
+			result.Allowed = true
+		} else {
+			result.RetryAfter = time.Duration(math.Ceil((1-state.tokens)/rate)) * time.Millisecond
+		}
+		result.Remaining = int(state.tokens)
+		result.Reset = time.Duration(math.Ceil((float64(rule.Burst)-state.tokens)/rate)) * time.Millisecond
+	default:
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", rule.Algorithm)
+	}
+	self.states.Set(key, state, rule.Window+result.Reset)
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RateLimitRule
+	}{
+		{name: "fixed window", rule: RateLimitRule{Algorithm: FixedWindow, Limit: 3, Window: time.Hour}},
+		{name: "sliding window", rule: RateLimitRule{Algorithm: SlidingWindow, Limit: 3, Window: time.Hour}},
+		{name: "token bucket", rule: RateLimitRule{Algorithm: TokenBucket, Limit: 3, Window: time.Hour, Burst: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryRateLimitStore(16)
+			ctx := context.Background()
+			for i := 0; i < 3; i++ {
+				result, err := store.Take(ctx, "a", tt.rule)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !result.Allowed || result.Remaining != 2-i {
+					t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i, 2-i, result)
+				}
+			}
+			result, err := store.Take(ctx, "a", tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 {
+				t.Errorf("expected denied with a retry delay, got %+v", result)
+			}
+			// keys are limited independently.
+			if result, _ = store.Take(ctx, "b", tt.rule); !result.Allowed {
+				t.Errorf("expected another key to be allowed, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore_Window(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RateLimitRule
+	}{
+		{name: "fixed window", rule: RateLimitRule{Algorithm: FixedWindow, Limit: 1, Window: 20 * time.Millisecond}},
+		{name: "sliding window", rule: RateLimitRule{Algorithm: SlidingWindow, Limit: 1, Window: 20 * time.Millisecond}},
+		{name: "token bucket", rule: RateLimitRule{Algorithm: TokenBucket, Limit: 1, Window: 20 * time.Millisecond, Burst: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryRateLimitStore(16)
+			ctx := context.Background()
+			if result, _ := store.Take(ctx, "a", tt.rule); !result.Allowed {
+				t.Fatalf("expected the first request to be allowed, got %+v", result)
+			}
+			if result, _ := store.Take(ctx, "a", tt.rule); result.Allowed {
+				t.Fatalf("expected the second request to be denied, got %+v", result)
+			}
+			time.Sleep(30 * time.Millisecond)
+			if result, _ := store.Take(ctx, "a", tt.rule); !result.Allowed {
+				t.Errorf("expected a request after the window to be allowed, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore_UnknownAlgorithm(t *testing.T) {
+	store := NewMemoryRateLimitStore(16)
+	if _, err := store.Take(context.Background(), "a", RateLimitRule{Algorithm: "leaky", Limit: 1, Window: time.Second}); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+}
@@ -0,0 +1,306 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gofiber/fiber/v2"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/config"
+	"github.com/wnnce/fserv-template/internal/constat"
+)
+
+// sessionLocalsKey is the fiber Locals key holding the *Session of a request.
+const sessionLocalsKey = "session"
+
+// SessionStore persists encoded sessions by ID. Get returns nil data without
+// an error when the session does not exist or has expired.
+type SessionStore interface {
+	Get(ctx context.Context, id string) ([]byte, error)
+	Set(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+	Touch(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// SessionConfig defines the configuration for the session middleware.
+type SessionConfig struct {
+	CookieName  string        // Name of the cookie holding the session ID.
+	Domain      string        // Cookie domain.
+	Path        string        // Cookie path.
+	Secure      bool          // Whether the cookie is only sent over HTTPS.
+	HTTPOnly    bool          // Whether the cookie is hidden from JavaScript.
+	SameSite    string        // Cookie SameSite attribute: Lax, Strict or None.
+	IdleTimeout time.Duration // A session expires after this long without requests.
+	Store       SessionStore  // Defaults to the Redis store, or to the memory store when Redis is not initialized.
+}
+
+// DefaultSessionConfig provides a default configuration for the session middleware.
+var DefaultSessionConfig = SessionConfig{
+	CookieName:  "session_id",
+	Path:        "/",
+	HTTPOnly:    true,
+	SameSite:    fiber.CookieSameSiteLaxMode,
+	IdleTimeout: 30 * time.Minute,
+}
+
+// ViperSessionConfig reads the session configuration under "session".
+func ViperSessionConfig() SessionConfig {
+	cfg := SessionConfig{
+		CookieName:  config.ViperGet[string]("session.cookie-name", DefaultSessionConfig.CookieName),
+		Domain:      config.ViperGet[string]("session.domain"),
+		Path:        config.ViperGet[string]("session.path", DefaultSessionConfig.Path),
+		Secure:      config.ViperGet[bool]("session.secure", false),
+		HTTPOnly:    config.ViperGet[bool]("session.http-only", DefaultSessionConfig.HTTPOnly),
+		SameSite:    config.ViperGet[string]("session.same-site", DefaultSessionConfig.SameSite),
+		IdleTimeout: config.ViperGet[time.Duration]("session.idle-timeout", DefaultSessionConfig.IdleTimeout),
+	}
+	if config.ViperGet[string]("session.store", "redis") == "memory" {
+		cfg.Store = NewMemorySessionStore(config.ViperGet[int]("session.memory-capacity", 10000))
+	} else if redis.RedisClient() != nil {
+		cfg.Store = NewRedisSessionStore(config.ViperGet[string]("session.prefix", "session"))
+	}
+	return cfg
+}
+
+// sessionConfigDefault fills in any missing values in the given SessionConfig
+// with defaults from DefaultSessionConfig.
+func sessionConfigDefault(cfg *SessionConfig) {
+	if cfg.CookieName == "" {
+		cfg.CookieName = DefaultSessionConfig.CookieName
+	}
+	if cfg.Path == "" {
+		cfg.Path = DefaultSessionConfig.Path
+	}
+	if cfg.SameSite == "" {
+		cfg.SameSite = DefaultSessionConfig.SameSite
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultSessionConfig.IdleTimeout
+	}
+	if cfg.Store == nil {
+		if redis.RedisClient() != nil {
+			cfg.Store = NewRedisSessionStore("session")
+		} else {
+			slog.Warn("redis is not initialized, session falls back to the memory store")
+			cfg.Store = NewMemorySessionStore(10000)
+		}
+	}
+}
+
+// Session is the server-side session of a request. Values are encoded with
+// sonic, so after a reload they come back as the types JSON decodes to; use
+// SessionValue to read them into a concrete type.
+type Session struct {
+	ctx       *fiber.Ctx
+	config    *SessionConfig
+	id        string
+	data      map[string]any
+	fresh     bool
+	modified  bool
+	destroyed bool
+}
+
+// SessionMiddleware creates a Fiber middleware handler that loads the session
+// named by the session cookie and makes it available through GetSession.
+//
+// If the session holds a user ID, it is stored as the userId context value so
+// that it appears in logs, rate limits and idempotency keys; the middleware
+// should therefore be registered after TraceMiddleware and before them.
+// Modified sessions are saved after the handler returns, and unmodified ones
+// have their expiration extended, so a session expires IdleTimeout after the
+// last request.
+func SessionMiddleware(config SessionConfig) fiber.Handler {
+	sessionConfigDefault(&config)
+	return func(ctx *fiber.Ctx) error {
+		session, err := loadSession(ctx, &config)
+		if err != nil {
+			return err
+		}
+		ctx.Locals(sessionLocalsKey, session)
+		if userID := session.UserID(); userID != "" {
+			ctx.SetUserContext(context.WithValue(ctx.UserContext(), constat.ContextUserIDKey, userID))
+		}
+		if err = ctx.Next(); err != nil {
+			return err
+		}
+		switch {
+		case session.destroyed:
+			return nil
+		case session.modified:
+			return session.Save()
+		case !session.fresh:
+			if err = config.Store.Touch(ctx.UserContext(), session.id, config.IdleTimeout); err != nil {
+				slog.WarnContext(ctx.UserContext(), "session touch failed", slog.String("error", err.Error()))
+			}
+			session.setCookie()
+		}
+		return nil
+	}
+}
+
+// GetSession returns the session of the request, or nil if SessionMiddleware
+// is not registered for the route.
+func GetSession(ctx *fiber.Ctx) *Session {
+	session, _ := ctx.Locals(sessionLocalsKey).(*Session)
+	return session
+}
+
+// SessionValue reads the value of key into T.
+//
+// Example:
+//
+//	profile, ok := middleware.SessionValue[Profile](middleware.GetSession(ctx), "profile")
+func SessionValue[T any](session *Session, key string) (T, bool) {
+	var result T
+	value, ok := session.data[key]
+	if !ok {
+		return result, false
+	}
+	if typed, ok := value.(T); ok {
+		return typed, true
+	}
+	data, err := sonic.Marshal(value)
+	if err != nil {
+		return result, false
+	}
+	if err = sonic.Unmarshal(data, &result); err != nil {
+		return result, false
+	}
+	return result, true
+}
+
+func loadSession(ctx *fiber.Ctx, config *SessionConfig) (*Session, error) {
+	session := &Session{ctx: ctx, config: config}
+	if id := ctx.Cookies(config.CookieName); id != "" {
+		data, err := config.Store.Get(ctx.UserContext(), id)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			values := make(map[string]any)
+			if err = sonic.Unmarshal(data, &values); err == nil {
+				session.id = id
+				session.data = values
+				return session, nil
+			}
+			slog.WarnContext(ctx.UserContext(), "session decode failed, starting a new session", slog.String("error", err.Error()))
+		}
+	}
+	session.id = newSessionID()
+	session.data = make(map[string]any)
+	session.fresh = true
+	return session, nil
+}
+
+// ID returns the session ID.
+func (self *Session) ID() string {
+	return self.id
+}
+
+// Fresh reports whether the session was created by this request.
+func (self *Session) Fresh() bool {
+	return self.fresh
+}
+
+// Get returns the value of key, or nil.
+func (self *Session) Get(key string) any {
+	return self.data[key]
+}
+
+// Set stores value under key.
+func (self *Session) Set(key string, value any) {
+	self.data[key] = value
+	self.modified = true
+}
+
+// Delete removes key.
+func (self *Session) Delete(key string) {
+	delete(self.data, key)
+	self.modified = true
+}
+
+// UserID returns the user ID stored with SetUserID.
+func (self *Session) UserID() string {
+	userID, _ := self.data[constat.ContextUserIDKey].(string)
+	return userID
+}
+
+// SetUserID stores the user ID in the session and in the request context.
+// Call Regenerate after login to prevent session fixation.
+func (self *Session) SetUserID(userID string) {
+	self.Set(constat.ContextUserIDKey, userID)
+	self.ctx.SetUserContext(context.WithValue(self.ctx.UserContext(), constat.ContextUserIDKey, userID))
+}
+
+// Save writes the session to the store and sets the cookie. It is called
+// automatically for modified sessions once the handler returns.
+func (self *Session) Save() error {
+	data, err := sonic.Marshal(self.data)
+	if err != nil {
+		return err
+	}
+	if err = self.config.Store.Set(self.ctx.UserContext(), self.id, data, self.config.IdleTimeout); err != nil {
+		return err
+	}
+	self.modified = false
+	self.fresh = false
+	self.setCookie()
+	return nil
+}
+
+// Regenerate moves the session data to a new ID and deletes the old one.
+func (self *Session) Regenerate() error {
+	if !self.fresh {
+		if err := self.config.Store.Delete(self.ctx.UserContext(), self.id); err != nil {
+			return err
+		}
+	}
+	self.id = newSessionID()
+	self.modified = true
+	return self.Save()
+}
+
+// Destroy deletes the session from the store and expires the cookie.
+func (self *Session) Destroy() error {
+	if err := self.config.Store.Delete(self.ctx.UserContext(), self.id); err != nil {
+		return err
+	}
+	clear(self.data)
+	self.destroyed = true
+	self.ctx.Cookie(&fiber.Cookie{
+		Name:     self.config.CookieName,
+		Domain:   self.config.Domain,
+		Path:     self.config.Path,
+		Secure:   self.config.Secure,
+		HTTPOnly: self.config.HTTPOnly,
+		SameSite: self.config.SameSite,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+	})
+	return nil
+}
+
+func (self *Session) setCookie() {
+	self.ctx.Cookie(&fiber.Cookie{
+		Name:     self.config.CookieName,
+		Value:    self.id,
+		Domain:   self.config.Domain,
+		Path:     self.config.Path,
+		Secure:   self.config.Secure,
+		HTTPOnly: self.config.HTTPOnly,
+		SameSite: self.config.SameSite,
+		Expires:  time.Now().Add(self.config.IdleTimeout),
+		MaxAge:   int(self.config.IdleTimeout.Seconds()),
+	})
+}
+
+// newSessionID returns 32 random bytes encoded as URL-safe base64.
+func newSessionID() string {
+	buffer := make([]byte, 32)
+	_, _ = rand.Read(buffer)
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/wnnce/fserv-template/biz/mw/redis"
+	"github.com/wnnce/fserv-template/pkg/tool"
+)
+
+// redisSessionStore keeps sessions in RedisClient under "<prefix>:<id>".
+type redisSessionStore struct {
+	prefix string
+}
+
+// NewRedisSessionStore creates a SessionStore backed by the default Redis client.
+func NewRedisSessionStore(prefix string) SessionStore {
+	return redisSessionStore{prefix: prefix}
+}
+
+func (self redisSessionStore) Get(ctx context.Context, id string) ([]byte, error) {
+	data, err := redis.RedisClient().Get(ctx, self.prefix+":"+id).Bytes()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (self redisSessionStore) Set(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return redis.RedisClient().Set(ctx, self.prefix+":"+id, data, ttl).Err()
+}
+
+func (self redisSessionStore) Delete(ctx context.Context, id string) error {
+	return redis.RedisClient().Del(ctx, self.prefix+":"+id).Err()
+}
+
+func (self redisSessionStore) Touch(ctx context.Context, id string, ttl time.Duration) error {
+	return redis.RedisClient().Expire(ctx, self.prefix+":"+id, ttl).Err()
+}
+
+// memorySessionStore keeps sessions in process. It is meant for development
+// without Redis: sessions are per instance and lost on restart.
+type memorySessionStore struct {
+	sessions *tool.LRU[string, []byte]
+}
+
+// NewMemorySessionStore creates an in-process SessionStore holding at most capacity sessions.
+func NewMemorySessionStore(capacity int) SessionStore {
+	return &memorySessionStore{
+		sessions: tool.NewLRU[string, []byte](capacity, nil),
+	}
+}
+
+func (self *memorySessionStore) Get(_ context.Context, id string) ([]byte, error) {
+	data, _ := self.sessions.Get(id)
+	return data, nil
+}
+
+func (self *memorySessionStore) Set(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	self.sessions.Set(id, data, ttl)
+	return nil
+}
+
+func (self *memorySessionStore) Delete(_ context.Context, id string) error {
+	self.sessions.Delete(id)
+	return nil
+}
+
+func (self *memorySessionStore) Touch(_ context.Context, id string, ttl time.Duration) error {
+	if data, ok := self.sessions.Get(id); ok {
+		self.sessions.Set(id, data, ttl)
+	}
+	return nil
+}
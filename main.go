@@ -13,8 +13,12 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/pprof"
 	recoverer "github.com/gofiber/fiber/v2/middleware/recover"
 	_ "github.com/wnnce/fserv-template/biz/dal"
+	// registers the declared topics, so SyncTopics and the topics command see them.
+	_ "github.com/wnnce/fserv-template/biz/event"
 	_ "github.com/wnnce/fserv-template/biz/mw"
+	"github.com/wnnce/fserv-template/biz/mw/kafka"
 	"github.com/wnnce/fserv-template/biz/route"
+	"github.com/wnnce/fserv-template/biz/route/ws"
 	"github.com/wnnce/fserv-template/config"
 	"github.com/wnnce/fserv-template/internal/constat"
 	"github.com/wnnce/fserv-template/internal/middleware"
@@ -37,6 +41,16 @@ func initialize() *fiber.App {
 		app.Use(pprof.New())
 	}
 	app.Use(middleware.TraceMiddleware())
+	if config.ViperGet[bool]("session.enable", false) {
+		app.Use(middleware.SessionMiddleware(middleware.ViperSessionConfig()))
+	}
+	if config.ViperGet[bool]("rate-limit.enable", false) {
+		rateLimitConfig, err := middleware.ViperRateLimitConfig()
+		if err != nil {
+			panic(err)
+		}
+		app.Use(middleware.RateLimitMiddleware(rateLimitConfig))
+	}
 	route.RegisterRouter(app)
 	customRouter(app)
 	return app
@@ -60,13 +74,27 @@ func main() {
 		panic(err)
 	}
 	slog.SetDefault(logger)
+	if len(os.Args) > 1 {
+		if err = runCommand(context.Background(), os.Args[1:]); err != nil {
+			slog.Error("run command failed", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		return
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	cleanup, err := config.DoReaderConfiguration(ctx)
 	if err != nil {
 		panic(err)
 	}
+	// the hub subscribes with the Redis client created by the readers above.
+	hubCleanup, err := ws.InitHub(ctx)
+	if err != nil {
+		cleanup()
+		panic(err)
+	}
 
 	defer func() {
+		hubCleanup()
 		cleanup()
 		cancel()
 	}()
@@ -79,9 +107,19 @@ func main() {
 	select {
 	case <-exit:
 		slog.Info("listen system exit signal, shutdown application!")
+		go func() {
+			<-exit
+			slog.Warn("listen second exit signal, exit without draining!")
+			os.Exit(1)
+		}()
 		if err = app.Shutdown(); err != nil {
 			slog.Info("shutdown app error", slog.String("error", err.Error()))
 		}
+		// stop accepting requests first, then let the Kafka consumers finish
+		// their in-flight records and commit before the remaining cleanup runs.
+		if service := kafka.Instance(); service != nil {
+			service.Shutdown()
+		}
 	case <-ctx.Done():
 		slog.Info("context canceled application exit")
 	}
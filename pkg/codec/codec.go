@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/bytedance/sonic"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes values to bytes and back. It is shared by the cache
+// serializers and the kafka record codecs.
+type Codec interface {
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, value any) error
+}
+
+var (
+	// JSON encodes values with sonic, matching the redis and kafka helpers.
+	JSON Codec = jsonCodec{}
+
+	// Msgpack encodes values with msgpack, which is more compact for large or
+	// numeric-heavy values.
+	Msgpack Codec = msgpackCodec{}
+
+	// Protobuf encodes proto.Message values. Unmarshal also accepts a pointer
+	// to a nil message pointer and allocates the message.
+	Protobuf Codec = protobufCodec{}
+
+	// Raw passes []byte and string values through unchanged.
+	Raw Codec = rawCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	return sonic.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value any) error {
+	return sonic.Unmarshal(data, value)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(value any) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, value any) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(value any) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", value)
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(data []byte, value any) error {
+	if message, ok := value.(proto.Message); ok {
+		return proto.Unmarshal(data, message)
+	}
+	// a pointer to a message pointer, as passed by typed kafka consumers.
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer && rv.Elem().Kind() == reflect.Pointer {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if message, ok := rv.Elem().Interface().(proto.Message); ok {
+			return proto.Unmarshal(data, message)
+		}
+	}
+	return fmt.Errorf("protobuf codec: %T is not a proto.Message", value)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("raw codec: unsupported type %T", value)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, value any) error {
+	switch v := value.(type) {
+	case *[]byte:
+		*v = data
+	case *string:
+		*v = string(data)
+	default:
+		return fmt.Errorf("raw codec: unsupported type %T", value)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecValue struct {
+	Name  string   `json:"name" msgpack:"name"`
+	Count int      `json:"count" msgpack:"count"`
+	Tags  []string `json:"tags" msgpack:"tags"`
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSON, "msgpack": Msgpack} {
+		t.Run(name, func(t *testing.T) {
+			expected := codecValue{Name: "a", Count: 3, Tags: []string{"x", "y"}}
+			data, err := codec.Marshal(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result codecValue
+			if err = codec.Unmarshal(data, &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("expected %v, got %v", expected, result)
+			}
+		})
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	data, err := Protobuf.Marshal(wrapperspb.String("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &wrapperspb.StringValue{}
+	if err = Protobuf.Unmarshal(data, message); err != nil || message.GetValue() != "a" {
+		t.Errorf("expected a, got %q %v", message.GetValue(), err)
+	}
+	// a pointer to a nil message pointer, as used by typed kafka consumers.
+	var pointer *wrapperspb.StringValue
+	if err = Protobuf.Unmarshal(data, &pointer); err != nil || pointer.GetValue() != "a" {
+		t.Errorf("expected a, got %q %v", pointer.GetValue(), err)
+	}
+	if _, err = Protobuf.Marshal("a"); err == nil {
+		t.Error("expected an error for a non-message value")
+	}
+	var value string
+	if err = Protobuf.Unmarshal(data, &value); err == nil {
+		t.Error("expected an error for a non-message target")
+	}
+}
+
+func TestRaw(t *testing.T) {
+	for _, value := range []any{"a", []byte("a")} {
+		data, err := Raw.Marshal(value)
+		if err != nil || string(data) != "a" {
+			t.Errorf("expected a, got %q %v", data, err)
+		}
+	}
+	if _, err := Raw.Marshal(1); err == nil {
+		t.Error("expected an error for an int value")
+	}
+	var text string
+	if err := Raw.Unmarshal([]byte("a"), &text); err != nil || text != "a" {
+		t.Errorf("expected a, got %q %v", text, err)
+	}
+	var data []byte
+	if err := Raw.Unmarshal([]byte("a"), &data); err != nil || string(data) != "a" {
+		t.Errorf("expected a, got %q %v", data, err)
+	}
+	var number int
+	if err := Raw.Unmarshal([]byte("a"), &number); err == nil {
+		t.Error("expected an error for an int target")
+	}
+}
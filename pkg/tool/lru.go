@@ -0,0 +1,114 @@
+package tool
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruEntry is a single value stored in an LRU together with its expiry.
+type lruEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	expireAt time.Time
+}
+
+// LRU is a fixed-capacity, concurrency-safe least-recently-used cache whose
+// entries can also expire after a per-entry TTL.
+type LRU[K comparable, V any] struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[K]*list.Element
+	order    *list.List
+	onEvict  func(key K, value V)
+}
+
+// NewLRU creates an LRU holding at most capacity entries. onEvict, if not nil,
+// is called for entries removed to make room; it is not called for expired or
+// deleted entries.
+func NewLRU[K comparable, V any](capacity int, onEvict func(key K, value V)) *LRU[K, V] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRU[K, V]{
+		capacity: capacity,
+		items:    make(map[K]*list.Element, capacity),
+		order:    list.New(),
+		onEvict:  onEvict,
+	}
+}
+
+// Get returns the value of key and marks it as recently used.
+func (self *LRU[K, V]) Get(key K) (V, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	var zero V
+	element, ok := self.items[key]
+	if !ok {
+		return zero, false
+	}
+	entry := element.Value.(*lruEntry[K, V])
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		self.removeElement(element)
+		return zero, false
+	}
+	self.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// Set stores value under key. A ttl of zero or less never expires.
+func (self *LRU[K, V]) Set(key K, value V, ttl time.Duration) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if element, ok := self.items[key]; ok {
+		entry := element.Value.(*lruEntry[K, V])
+		entry.value, entry.expireAt = value, expireAt
+		self.order.MoveToFront(element)
+		return
+	}
+	self.items[key] = self.order.PushFront(&lruEntry[K, V]{key: key, value: value, expireAt: expireAt})
+	if self.order.Len() <= self.capacity {
+		return
+	}
+	oldest := self.order.Back()
+	entry := oldest.Value.(*lruEntry[K, V])
+	self.removeElement(oldest)
+	if self.onEvict != nil {
+		self.onEvict(entry.key, entry.value)
+	}
+}
+
+// Delete removes key and reports whether it was present.
+func (self *LRU[K, V]) Delete(key K) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	element, ok := self.items[key]
+	if ok {
+		self.removeElement(element)
+	}
+	return ok
+}
+
+// Len returns the number of entries, including expired ones not yet removed.
+func (self *LRU[K, V]) Len() int {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.order.Len()
+}
+
+// Clear removes every entry.
+func (self *LRU[K, V]) Clear() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	clear(self.items)
+	self.order.Init()
+}
+
+func (self *LRU[K, V]) removeElement(element *list.Element) {
+	self.order.Remove(element)
+	delete(self.items, element.Value.(*lruEntry[K, V]).key)
+}
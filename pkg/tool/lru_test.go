@@ -0,0 +1,59 @@
+package tool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU_Evict(t *testing.T) {
+	evicted := make([]string, 0)
+	cache := NewLRU[string, int](2, func(key string, _ int) {
+		evicted = append(evicted, key)
+	})
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	// touch a so that b becomes the least recently used entry
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Errorf("expected 1, got %v %v", v, ok)
+	}
+	cache.Set("c", 3, 0)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("expected [b] evicted, got %v", evicted)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("expected len 2, got %d", cache.Len())
+	}
+}
+
+func TestLRU_TTL(t *testing.T) {
+	cache := NewLRU[string, int](4, nil)
+	cache.Set("a", 1, time.Millisecond)
+	cache.Set("b", 2, 0)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected a to be expired")
+	}
+	if v, ok := cache.Get("b"); !ok || v != 2 {
+		t.Errorf("expected 2, got %v %v", v, ok)
+	}
+}
+
+func TestLRU_DeleteAndClear(t *testing.T) {
+	cache := NewLRU[string, int](4, nil)
+	cache.Set("a", 1, 0)
+	cache.Set("a", 10, 0)
+	if v, _ := cache.Get("a"); v != 10 {
+		t.Errorf("expected overwrite to 10, got %v", v)
+	}
+	if !cache.Delete("a") || cache.Delete("a") {
+		t.Error("expected first delete to succeed and second to fail")
+	}
+	cache.Set("b", 2, 0)
+	cache.Clear()
+	if cache.Len() != 0 {
+		t.Errorf("expected empty cache, got %d", cache.Len())
+	}
+}